@@ -0,0 +1,519 @@
+package azuretls
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+	"weak"
+
+	tls "github.com/Noooste/utls"
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyteasn1 "golang.org/x/crypto/cryptobyte/asn1"
+	"golang.org/x/crypto/ocsp"
+)
+
+// ErrNoOCSPStaple is returned when CertificateChecks.RequireOCSPStaple is set
+// and the server did not staple an OCSP response.
+var ErrNoOCSPStaple = errors.New("azuretls: server stapled no OCSP response")
+
+// RevokedCertificateError is returned when a certificate of the chain of a
+// server is revoked.
+type RevokedCertificateError struct {
+	Certificate *x509.Certificate
+	// Source of the revocation: "ocsp", "crl" or "check" for CertificateChecks.IsRevoked.
+	Source string
+	// RevokedAt is the revocation time, zero if unknown.
+	RevokedAt time.Time
+}
+
+func (e *RevokedCertificateError) Error() string {
+	return fmt.Sprintf("azuretls: certificate %q is revoked (%s)", e.Certificate.Subject.String(), e.Source)
+}
+
+// SCTError is returned when the certificate of a server does not have enough
+// valid Signed Certificate Timestamps.
+type SCTError struct {
+	// Valid is the number of distinct CT logs with a valid SCT.
+	Valid int
+	// Required is CertificateChecks.MinSCTs.
+	Required int
+}
+
+func (e *SCTError) Error() string {
+	return fmt.Sprintf("azuretls: %d valid SCTs from distinct CT logs, %d required", e.Valid, e.Required)
+}
+
+// CertificateChecks configures the revocation and Certificate Transparency
+// checks of the certificates of servers, see Session.CertificateChecks.
+// Failures are returned as RevokedCertificateError, SCTError or ErrNoOCSPStaple.
+type CertificateChecks struct {
+	// OCSP verifies the OCSP response stapled by servers.
+	OCSP bool
+	// RequireOCSPStaple fails the handshake when the server staples no OCSP response.
+	RequireOCSPStaple bool
+
+	// CRLs are revocation lists checked against the certificates of the chain, see LoadCRL.
+	CRLs []*x509.RevocationList
+	// IsRevoked reports whether cert, issued by issuer, is revoked, e.g. with a CRLite filter.
+	IsRevoked func(cert, issuer *x509.Certificate) bool
+
+	// CTLogs are the Certificate Transparency logs trusted to verify the SCTs of
+	// certificates, see ParseCTLogList. If empty, SCTs are not checked.
+	CTLogs []CTLog
+	// MinSCTs is the number of distinct CT logs with a valid SCT required,
+	// 2 if zero like the Chrome CT policy.
+	MinSCTs int
+}
+
+// CTLog is a Certificate Transparency log.
+type CTLog struct {
+	Description string `json:"description"`
+	// Key is the DER encoded public key of the log.
+	Key []byte `json:"key"`
+}
+
+// CertificateStatus is the result of the CertificateChecks of a connection,
+// see Response.CertificateStatus.
+type CertificateStatus struct {
+	// OCSPStapled reports whether the server stapled an OCSP response.
+	OCSPStapled bool
+	// OCSPStatus is the status of the stapled response: "good", "revoked" or "unknown".
+	OCSPStatus string
+	// SCTs are the Signed Certificate Timestamps verified against CertificateChecks.CTLogs.
+	SCTs []SCT
+}
+
+// SCT is a verified Signed Certificate Timestamp.
+type SCT struct {
+	// Log is the description of the CT log.
+	Log   string
+	LogID [32]byte
+	// Timestamp is the time the log included the certificate.
+	Timestamp time.Time
+	// Source is where the SCT was found: "tls", "ocsp" or "certificate".
+	Source string
+}
+
+var ocspStatuses = map[int]string{
+	ocsp.Good:    "good",
+	ocsp.Revoked: "revoked",
+	ocsp.Unknown: "unknown",
+}
+
+var (
+	oidSCTList     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+	oidOCSPSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 5}
+)
+
+// LoadCRL reads a certificate revocation list, PEM or DER encoded.
+func LoadCRL(file string) (*x509.RevocationList, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+
+	return x509.ParseRevocationList(data)
+}
+
+// ParseCTLogList returns the logs of a CT log list in the JSON format
+// published by Google (https://www.gstatic.com/ct/log_list/v3/log_list.json).
+func ParseCTLogList(data []byte) ([]CTLog, error) {
+	var list struct {
+		Operators []struct {
+			Logs      []CTLog `json:"logs"`
+			TiledLogs []CTLog `json:"tiled_logs"`
+		} `json:"operators"`
+	}
+
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+
+	var logs []CTLog
+	for _, operator := range list.Operators {
+		logs = append(logs, operator.Logs...)
+		logs = append(logs, operator.TiledLogs...)
+	}
+
+	return logs, nil
+}
+
+// applyCertificateChecks makes config run the CertificateChecks of the session.
+func (s *Session) applyCertificateChecks(config *tls.Config) {
+	if s.CertificateChecks == nil || config.InsecureSkipVerify {
+		return
+	}
+
+	checks := s.CertificateChecks
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		status, err := checks.check(cs)
+		if err != nil {
+			return err
+		}
+
+		s.storeCertificateStatus(cs.PeerCertificates[0], status)
+		return nil
+	}
+}
+
+// storeCertificateStatus records the status of the connection whose leaf
+// certificate is leaf. The ConnectionState of the responses of the connection
+// shares the certificate, which identifies the connection; the status is
+// removed when the certificate is collected.
+func (s *Session) storeCertificateStatus(leaf *x509.Certificate, status *CertificateStatus) {
+	key := weak.Make(leaf)
+
+	if _, loaded := s.certificateStatuses.Swap(key, status); !loaded {
+		runtime.AddCleanup(leaf, func(key weak.Pointer[x509.Certificate]) {
+			s.certificateStatuses.Delete(key)
+		}, key)
+	}
+}
+
+// certificateStatus returns the status recorded for the connection of cs
+// when it was established, or nil.
+func (s *Session) certificateStatus(cs *tls.ConnectionState) *CertificateStatus {
+	if len(cs.PeerCertificates) == 0 {
+		return nil
+	}
+
+	if status, ok := s.certificateStatuses.Load(weak.Make(cs.PeerCertificates[0])); ok {
+		return status.(*CertificateStatus)
+	}
+	return nil
+}
+
+// check runs the checks on the certificates of cs. The status is returned
+// with the first failure.
+func (c *CertificateChecks) check(cs tls.ConnectionState) (*CertificateStatus, error) {
+	chain := cs.PeerCertificates
+	if len(cs.VerifiedChains) > 0 {
+		chain = cs.VerifiedChains[0]
+	}
+
+	if len(chain) == 0 {
+		return nil, errors.New("azuretls: server sent no certificate")
+	}
+
+	leaf := chain[0]
+
+	var issuer *x509.Certificate
+	if len(chain) > 1 {
+		issuer = chain[1]
+	}
+
+	status := &CertificateStatus{OCSPStapled: len(cs.OCSPResponse) > 0}
+
+	var (
+		ocspResponse *ocsp.Response
+		ocspErr      error
+	)
+
+	if status.OCSPStapled {
+		if issuer == nil {
+			ocspErr = errors.New("issuer certificate not sent")
+		} else {
+			ocspResponse, ocspErr = ocsp.ParseResponseForCert(cs.OCSPResponse, leaf, issuer)
+		}
+	}
+
+	if ocspResponse != nil {
+		status.OCSPStatus = ocspStatuses[ocspResponse.Status]
+	}
+
+	if c.OCSP || c.RequireOCSPStaple {
+		switch {
+		case !status.OCSPStapled && c.RequireOCSPStaple:
+			return status, ErrNoOCSPStaple
+		case ocspErr != nil:
+			return status, fmt.Errorf("azuretls: invalid OCSP response: %w", ocspErr)
+		case ocspResponse != nil && ocspResponse.Status == ocsp.Revoked:
+			return status, &RevokedCertificateError{Certificate: leaf, Source: "ocsp", RevokedAt: ocspResponse.RevokedAt}
+		}
+	}
+
+	for i := 0; i+1 < len(chain); i++ {
+		if err := c.checkRevoked(chain[i], chain[i+1]); err != nil {
+			return status, err
+		}
+	}
+
+	if len(c.CTLogs) == 0 {
+		return status, nil
+	}
+
+	status.SCTs = c.verifySCTs(cs, leaf, issuer, ocspResponse)
+
+	required := c.MinSCTs
+	if required == 0 {
+		required = 2
+	}
+
+	logs := make(map[[32]byte]bool, len(status.SCTs))
+	for _, sct := range status.SCTs {
+		logs[sct.LogID] = true
+	}
+
+	if len(logs) < required {
+		return status, &SCTError{Valid: len(logs), Required: required}
+	}
+
+	return status, nil
+}
+
+// checkRevoked checks cert against CRLs and IsRevoked.
+func (c *CertificateChecks) checkRevoked(cert, issuer *x509.Certificate) error {
+	for _, crl := range c.CRLs {
+		if !bytes.Equal(crl.RawIssuer, cert.RawIssuer) || crl.CheckSignatureFrom(issuer) != nil {
+			continue
+		}
+
+		for _, entry := range crl.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return &RevokedCertificateError{Certificate: cert, Source: "crl", RevokedAt: entry.RevocationTime}
+			}
+		}
+	}
+
+	if c.IsRevoked != nil && c.IsRevoked(cert, issuer) {
+		return &RevokedCertificateError{Certificate: cert, Source: "check"}
+	}
+
+	return nil
+}
+
+// verifySCTs returns the valid SCTs sent in the TLS handshake, the OCSP
+// response and the certificate itself.
+func (c *CertificateChecks) verifySCTs(cs tls.ConnectionState, leaf, issuer *x509.Certificate, ocspResponse *ocsp.Response) []SCT {
+	var scts []SCT
+
+	verify := func(raw []byte, source string, precert bool) {
+		if sct, ok := c.verifySCT(raw, leaf, issuer, precert); ok {
+			sct.Source = source
+			scts = append(scts, sct)
+		}
+	}
+
+	for _, raw := range cs.SignedCertificateTimestamps {
+		verify(raw, "tls", false)
+	}
+
+	if ocspResponse != nil {
+		for _, ext := range ocspResponse.Extensions {
+			if ext.Id.Equal(oidOCSPSCTList) {
+				for _, raw := range parseSCTList(ext.Value) {
+					verify(raw, "ocsp", false)
+				}
+			}
+		}
+	}
+
+	// embedded SCTs sign the precertificate, which needs the issuer key
+	if issuer != nil {
+		for _, ext := range leaf.Extensions {
+			if ext.Id.Equal(oidSCTList) {
+				for _, raw := range parseSCTList(ext.Value) {
+					verify(raw, "certificate", true)
+				}
+			}
+		}
+	}
+
+	return scts
+}
+
+// parseSCTList parses the SignedCertificateTimestampList of an X.509 or OCSP extension.
+func parseSCTList(value []byte) [][]byte {
+	var list []byte
+	if _, err := asn1.Unmarshal(value, &list); err != nil {
+		return nil
+	}
+
+	var (
+		s    = cryptobyte.String(list)
+		scts cryptobyte.String
+		raws [][]byte
+	)
+
+	if !s.ReadUint16LengthPrefixed(&scts) {
+		return nil
+	}
+
+	for !scts.Empty() {
+		var sct cryptobyte.String
+		if !scts.ReadUint16LengthPrefixed(&sct) {
+			return raws
+		}
+		raws = append(raws, sct)
+	}
+
+	return raws
+}
+
+// verifySCT verifies the signature of a serialized SCT (RFC 6962, section 3.2).
+func (c *CertificateChecks) verifySCT(raw []byte, leaf, issuer *x509.Certificate, precert bool) (SCT, bool) {
+	var (
+		s                cryptobyte.String = raw
+		version          uint8
+		logID            []byte
+		timestamp        uint64
+		extensions, sig  cryptobyte.String
+		hashAlg, signAlg uint8
+	)
+
+	if !s.ReadUint8(&version) || version != 0 || !s.ReadBytes(&logID, 32) || !s.ReadUint64(&timestamp) ||
+		!s.ReadUint16LengthPrefixed(&extensions) || !s.ReadUint8(&hashAlg) || !s.ReadUint8(&signAlg) ||
+		!s.ReadUint16LengthPrefixed(&sig) || !s.Empty() || hashAlg != 4 {
+		return SCT{}, false
+	}
+
+	sct := SCT{Timestamp: time.UnixMilli(int64(timestamp))}
+	copy(sct.LogID[:], logID)
+
+	var key []byte
+	for _, log := range c.CTLogs {
+		if sha256.Sum256(log.Key) == sct.LogID {
+			sct.Log, key = log.Description, log.Key
+			break
+		}
+	}
+
+	if key == nil {
+		return SCT{}, false
+	}
+
+	var b cryptobyte.Builder
+	b.AddUint8(0) // v1
+	b.AddUint8(0) // certificate_timestamp
+	b.AddUint64(timestamp)
+
+	if precert {
+		tbs, err := removeSCTList(leaf.RawTBSCertificate)
+		if err != nil {
+			return SCT{}, false
+		}
+
+		issuerKeyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+
+		b.AddUint16(1) // precert_entry
+		b.AddBytes(issuerKeyHash[:])
+		b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(tbs) })
+	} else {
+		b.AddUint16(0) // x509_entry
+		b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(leaf.Raw) })
+	}
+
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(extensions) })
+
+	signed, err := b.Bytes()
+	if err != nil {
+		return SCT{}, false
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(key)
+	if err != nil {
+		return SCT{}, false
+	}
+
+	digest := sha256.Sum256(signed)
+
+	switch publicKey := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		if signAlg != 3 || !ecdsa.VerifyASN1(publicKey, digest[:], sig) {
+			return SCT{}, false
+		}
+	case *rsa.PublicKey:
+		if signAlg != 1 || rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], sig) != nil {
+			return SCT{}, false
+		}
+	default:
+		return SCT{}, false
+	}
+
+	return sct, true
+}
+
+// removeSCTList returns the TBSCertificate of the precertificate logged for a
+// certificate: its TBSCertificate without the SCT list extension.
+func removeSCTList(tbs []byte) ([]byte, error) {
+	var (
+		input  = cryptobyte.String(tbs)
+		fields cryptobyte.String
+		b      cryptobyte.Builder
+	)
+
+	if !input.ReadASN1(&fields, cryptobyteasn1.SEQUENCE) {
+		return nil, errors.New("malformed TBSCertificate")
+	}
+
+	extensionsTag := cryptobyteasn1.Tag(3).Constructed().ContextSpecific()
+
+	b.AddASN1(cryptobyteasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		for !fields.Empty() {
+			var (
+				field cryptobyte.String
+				tag   cryptobyteasn1.Tag
+			)
+
+			if !fields.ReadAnyASN1Element(&field, &tag) {
+				b.SetError(errors.New("malformed TBSCertificate"))
+				return
+			}
+
+			if tag != extensionsTag {
+				b.AddBytes(field)
+				continue
+			}
+
+			var wrapper, extensions cryptobyte.String
+			if !field.ReadASN1(&wrapper, extensionsTag) || !wrapper.ReadASN1(&extensions, cryptobyteasn1.SEQUENCE) {
+				b.SetError(errors.New("malformed extensions"))
+				return
+			}
+
+			b.AddASN1(extensionsTag, func(b *cryptobyte.Builder) {
+				b.AddASN1(cryptobyteasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					for !extensions.Empty() {
+						var (
+							extension cryptobyte.String
+							body      cryptobyte.String
+							oid       asn1.ObjectIdentifier
+						)
+
+						if !extensions.ReadASN1Element(&extension, cryptobyteasn1.SEQUENCE) {
+							b.SetError(errors.New("malformed extension"))
+							return
+						}
+
+						element := extension
+						if !element.ReadASN1(&body, cryptobyteasn1.SEQUENCE) || !body.ReadASN1ObjectIdentifier(&oid) {
+							b.SetError(errors.New("malformed extension"))
+							return
+						}
+
+						if !oid.Equal(oidSCTList) {
+							b.AddBytes(extension)
+						}
+					}
+				})
+			})
+		}
+	})
+
+	return b.Bytes()
+}
@@ -51,6 +51,8 @@ char* azuretls_session_clear_pins(uintptr_t session_id, char* url);
 // Utility functions
 char* azuretls_session_get_ip(uintptr_t session_id);
 char* azuretls_session_get_cookies(uintptr_t session_id, char* url);
+char* azuretls_session_export_cookies(uintptr_t session_id, char* format);
+char* azuretls_session_import_cookies(uintptr_t session_id, char* data, char* format);
 char* azuretls_version(void);
 
 // Library lifecycle functions
@@ -46,11 +46,13 @@ typedef struct {
 import "C"
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/url"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 	"unsafe"
@@ -583,6 +585,46 @@ func azuretls_session_get_cookies(sessionID uintptr, urlStr *C.char) *C.char {
 	return goStringToCString(string(cookiesJSON))
 }
 
+//export azuretls_session_export_cookies
+func azuretls_session_export_cookies(sessionID uintptr, format *C.char) *C.char {
+	session, exists := sessionManager.getSession(sessionID)
+	if !exists {
+		return goStringToCString("error: session not found")
+	}
+
+	jar, ok := session.CookieJar.(*azuretls.CookieJar)
+	if !ok {
+		return goStringToCString("error: cookie jar does not support export")
+	}
+
+	var buf bytes.Buffer
+	if err := jar.Export(&buf, azuretls.CookieFormat(cStringToGoString(format))); err != nil {
+		return goStringToCString(fmt.Sprintf("error: %v", err))
+	}
+
+	return goStringToCString(buf.String())
+}
+
+//export azuretls_session_import_cookies
+func azuretls_session_import_cookies(sessionID uintptr, data *C.char, format *C.char) *C.char {
+	session, exists := sessionManager.getSession(sessionID)
+	if !exists {
+		return goStringToCString("session not found")
+	}
+
+	jar, ok := session.CookieJar.(*azuretls.CookieJar)
+	if !ok {
+		return goStringToCString("cookie jar does not support import")
+	}
+
+	reader := strings.NewReader(cStringToGoString(data))
+	if _, err := jar.Import(reader, azuretls.CookieFormat(cStringToGoString(format))); err != nil {
+		return goStringToCString(err.Error())
+	}
+
+	return nil
+}
+
 //export azuretls_free_string
 func azuretls_free_string(str *C.char) {
 	if str != nil {
@@ -0,0 +1,104 @@
+package azuretls
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"os"
+
+	tls "github.com/Noooste/utls"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// LoadClientCertificate reads a client certificate, followed by its chain, and
+// its private key from PEM files. certFile and keyFile can be the same file.
+func LoadClientCertificate(certFile, keyFile string) (tls.Certificate, error) {
+	return tls.LoadX509KeyPair(certFile, keyFile)
+}
+
+// ParseClientCertificate parses a PEM encoded client certificate, followed by
+// its chain, and its private key.
+func ParseClientCertificate(certPEM, keyPEM []byte) (tls.Certificate, error) {
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// LoadPKCS12ClientCertificate reads a client certificate, its chain and its
+// private key from a PKCS#12 file (.p12, .pfx).
+func LoadPKCS12ClientCertificate(file, password string) (tls.Certificate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return ParsePKCS12ClientCertificate(data, password)
+}
+
+// ParsePKCS12ClientCertificate parses a PKCS#12 archive holding a client
+// certificate, its chain and its private key. Archives encrypted with the
+// default algorithms of OpenSSL 3 (AES-256, PBKDF2) and with the legacy ones
+// (3DES, RC2) are supported.
+func ParsePKCS12ClientCertificate(data []byte, password string) (tls.Certificate, error) {
+	key, leaf, chain, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return tls.Certificate{}, errors.New("pkcs12: unsupported private key")
+	}
+
+	// the archive does not tell which certificate is the leaf: it is the one
+	// matching the private key, the others are its chain
+	certs := append([]*x509.Certificate{leaf}, chain...)
+
+	for i, cert := range certs {
+		publicKey, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+		if !ok || !publicKey.Equal(signer.Public()) {
+			continue
+		}
+
+		certificate := tls.Certificate{
+			Certificate: [][]byte{cert.Raw},
+			PrivateKey:  key,
+			Leaf:        cert,
+		}
+
+		for j, c := range certs {
+			if j != i {
+				certificate.Certificate = append(certificate.Certificate, c.Raw)
+			}
+		}
+
+		return certificate, nil
+	}
+
+	return tls.Certificate{}, errors.New("pkcs12: no certificate matches the private key")
+}
+
+// clientCertificate returns the tls.Config.GetClientCertificate function of
+// connections to host, selecting the certificate with GetClientCertificate,
+// then among ClientCertificates.
+func (s *Session) clientCertificate(host string) func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if s.GetClientCertificate == nil && len(s.ClientCertificates) == 0 {
+		return nil
+	}
+
+	return func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		if s.GetClientCertificate != nil {
+			cert, err := s.GetClientCertificate(host, info)
+			if err != nil || cert != nil {
+				return cert, err
+			}
+		}
+
+		for i := range s.ClientCertificates {
+			if info.SupportsCertificate(&s.ClientCertificates[i]) == nil {
+				return &s.ClientCertificates[i], nil
+			}
+		}
+
+		// no certificate is sent, the server decides whether to continue
+		return &tls.Certificate{}, nil
+	}
+}
@@ -0,0 +1,322 @@
+package azuretls
+
+import (
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	http "github.com/Noooste/fhttp"
+)
+
+var (
+	chromiumVersionReg = regexp.MustCompile(`Chrome/(\d+)`)
+	edgeVersionReg     = regexp.MustCompile(`Edg(?:A|iOS)?/(\d+)`)
+	operaVersionReg    = regexp.MustCompile(`OPR/(\d+)`)
+)
+
+// greaseyChars and greasedVersions are used by Chromium to build the GREASE brand of sec-ch-ua.
+var (
+	greaseyChars    = []string{" ", "(", ":", "-", ".", "/", ")", ";", "=", "?", "_"}
+	greasedVersions = []string{"8", "99", "24"}
+	brandOrders     = [6][3]int{{0, 1, 2}, {0, 2, 1}, {1, 0, 2}, {1, 2, 0}, {2, 0, 1}, {2, 1, 0}}
+)
+
+// chromiumFullVersions are the stable full versions sent in sec-ch-ua-full-version-list,
+// other versions use "<major>.0.0.0".
+var chromiumFullVersions = map[string]string{
+	"133": "133.0.6943.142",
+	"134": "134.0.6998.166",
+	"135": "135.0.7049.115",
+}
+
+// Client hint header names.
+const (
+	HintUA              = "sec-ch-ua"
+	HintMobile          = "sec-ch-ua-mobile"
+	HintPlatform        = "sec-ch-ua-platform"
+	HintFullVersion     = "sec-ch-ua-full-version"
+	HintArch            = "sec-ch-ua-arch"
+	HintPlatformVersion = "sec-ch-ua-platform-version"
+	HintModel           = "sec-ch-ua-model"
+	HintBitness         = "sec-ch-ua-bitness"
+	HintWoW64           = "sec-ch-ua-wow64"
+	HintFullVersionList = "sec-ch-ua-full-version-list"
+	HintFormFactors     = "sec-ch-ua-form-factors"
+)
+
+// highEntropyHints are the hints only sent to origins asking for them with
+// Accept-CH, in the order Chromium sends them.
+var highEntropyHints = []string{
+	HintFullVersion, HintArch, HintPlatformVersion, HintModel,
+	HintBitness, HintWoW64, HintFullVersionList, HintFormFactors,
+}
+
+// ClientHintBrand is a brand of the sec-ch-ua and sec-ch-ua-full-version-list headers.
+type ClientHintBrand struct {
+	Brand   string
+	Version string
+}
+
+// ClientHints are the User-Agent Client Hints of a Chromium based browser.
+// Session.ClientHints overrides the hints derived from the session user agent.
+type ClientHints struct {
+	// Brands is sent in sec-ch-ua, with the major versions.
+	Brands []ClientHintBrand
+	// FullVersionList is sent in sec-ch-ua-full-version-list, in the order of Brands.
+	FullVersionList []ClientHintBrand
+
+	Mobile          bool
+	Platform        string
+	PlatformVersion string
+	Architecture    string
+	Bitness         string
+	Model           string
+	FullVersion     string
+	WoW64           bool
+	FormFactors     []string
+}
+
+// NewClientHints returns the client hints a browser sending ua would send,
+// or nil if ua is not a Chromium based user agent. High-entropy values which
+// are not in the user agent are the ones of a common device of the platform.
+func NewClientHints(ua string) *ClientHints {
+	m := chromiumVersionReg.FindStringSubmatch(ua)
+	if m == nil || strings.Contains(ua, "CriOS/") {
+		// Chrome on iOS is WebKit and does not send client hints
+		return nil
+	}
+
+	chromium := m[1]
+	seed, _ := strconv.Atoi(chromium)
+
+	brand := ClientHintBrand{"Google Chrome", chromium}
+	if m := edgeVersionReg.FindStringSubmatch(ua); m != nil {
+		brand = ClientHintBrand{"Microsoft Edge", m[1]}
+	} else if m := operaVersionReg.FindStringSubmatch(ua); m != nil {
+		brand = ClientHintBrand{"Opera", m[1]}
+	}
+
+	grease := ClientHintBrand{
+		Brand:   "Not" + greaseyChars[seed%len(greaseyChars)] + "A" + greaseyChars[(seed+1)%len(greaseyChars)] + "Brand",
+		Version: greasedVersions[seed%len(greasedVersions)],
+	}
+
+	list := [3]ClientHintBrand{grease, {"Chromium", chromium}, brand}
+	order := brandOrders[seed%len(brandOrders)]
+
+	ch := &ClientHints{
+		Brands:          make([]ClientHintBrand, 3),
+		FullVersionList: make([]ClientHintBrand, 3),
+		Mobile:          strings.Contains(ua, "Mobile"),
+		Platform:        userAgentPlatform(ua),
+		FullVersion:     fullVersion(chromium),
+	}
+
+	for i, b := range list {
+		ch.Brands[order[i]] = b
+		ch.FullVersionList[order[i]] = ClientHintBrand{b.Brand, fullVersion(b.Version)}
+	}
+
+	switch ch.Platform {
+	case "Windows":
+		ch.PlatformVersion, ch.Architecture, ch.Bitness = "19.0.0", "x86", "64"
+	case "macOS":
+		ch.PlatformVersion, ch.Architecture, ch.Bitness = "15.3.0", "arm", "64"
+	case "Linux":
+		ch.PlatformVersion, ch.Architecture, ch.Bitness = "6.8.0", "x86", "64"
+	case "Chrome OS":
+		ch.PlatformVersion, ch.Architecture, ch.Bitness = "16181.61.0", "x86", "64"
+	case "Android":
+		ch.PlatformVersion = "14.0.0"
+	}
+
+	if ch.Mobile {
+		ch.FormFactors = []string{"Mobile"}
+	} else {
+		ch.FormFactors = []string{"Desktop"}
+	}
+
+	return ch
+}
+
+func fullVersion(major string) string {
+	if v, ok := chromiumFullVersions[major]; ok {
+		return v
+	}
+	return major + ".0.0.0"
+}
+
+// userAgentPlatform returns the sec-ch-ua-platform value of a user agent.
+func userAgentPlatform(ua string) string {
+	switch {
+	case strings.Contains(ua, "Windows"):
+		return "Windows"
+	case strings.Contains(ua, "Android"):
+		return "Android"
+	case strings.Contains(ua, "CrOS"):
+		return "Chrome OS"
+	case strings.Contains(ua, "Macintosh"):
+		return "macOS"
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"):
+		return "iOS"
+	case strings.Contains(ua, "Linux"):
+		return "Linux"
+	}
+	return "Unknown"
+}
+
+func formatBrands(brands []ClientHintBrand) string {
+	parts := make([]string, 0, len(brands))
+	for _, b := range brands {
+		parts = append(parts, strconv.Quote(b.Brand)+";v="+strconv.Quote(b.Version))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatBool(b bool) string {
+	if b {
+		return "?1"
+	}
+	return "?0"
+}
+
+// Header returns the value of the client hint header name, e.g. "sec-ch-ua-platform",
+// or an empty string if name is not a client hint.
+func (ch *ClientHints) Header(name string) string {
+	switch strings.ToLower(name) {
+	case HintUA:
+		return formatBrands(ch.Brands)
+	case HintMobile:
+		return formatBool(ch.Mobile)
+	case HintPlatform:
+		return strconv.Quote(ch.Platform)
+	case HintFullVersion:
+		return strconv.Quote(ch.FullVersion)
+	case HintArch:
+		return strconv.Quote(ch.Architecture)
+	case HintPlatformVersion:
+		return strconv.Quote(ch.PlatformVersion)
+	case HintModel:
+		return strconv.Quote(ch.Model)
+	case HintBitness:
+		return strconv.Quote(ch.Bitness)
+	case HintWoW64:
+		return formatBool(ch.WoW64)
+	case HintFullVersionList:
+		return formatBrands(ch.FullVersionList)
+	case HintFormFactors:
+		parts := make([]string, 0, len(ch.FormFactors))
+		for _, f := range ch.FormFactors {
+			parts = append(parts, strconv.Quote(f))
+		}
+		return strings.Join(parts, ", ")
+	}
+	return ""
+}
+
+// clientHints returns the client hints of the session for ua, or nil if the
+// session browser does not send client hints.
+func (s *Session) clientHints(ua string) *ClientHints {
+	if s.ClientHints != nil {
+		return s.ClientHints
+	}
+	return NewClientHints(ua)
+}
+
+// parseHintList parses the list of hint names of an Accept-CH or Critical-CH header.
+func parseHintList(values []string) []string {
+	var hints []string
+	for _, v := range values {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				hints = append(hints, name)
+			}
+		}
+	}
+	return hints
+}
+
+func isHighEntropyHint(name string) bool {
+	for _, h := range highEntropyHints {
+		if h == name {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptedClientHints returns the high-entropy hints requested by the origin of u with Accept-CH.
+func (s *Session) acceptedClientHints(u *url.URL) map[string]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.acceptCH[origin(fetchURL(u))]
+}
+
+// handleClientHints stores the hints requested by the Accept-CH header of a
+// navigation response, and reports whether the request must be sent again
+// because a hint listed in Critical-CH was missing.
+func (s *Session) handleClientHints(req *Request, resp *Response) bool {
+	if req.Mode != ModeNavigate || resp == nil || req.parsedUrl == nil || req.parsedUrl.Scheme != SchemeHttps {
+		return false
+	}
+
+	if _, ok := req.Body.(io.Reader); ok {
+		// the body cannot be sent again
+		return false
+	}
+
+	if browserFamilyOf(req.browser) != familyChromium || s.clientHints(req.ua) == nil {
+		return false
+	}
+
+	values, ok := resp.Header[http.CanonicalHeaderKey("Accept-CH")]
+	if !ok {
+		return false
+	}
+
+	accepted := make(map[string]bool)
+	for _, name := range parseHintList(values) {
+		if isHighEntropyHint(name) {
+			accepted[name] = true
+		}
+	}
+
+	s.mu.Lock()
+	if s.acceptCH == nil {
+		s.acceptCH = make(map[string]map[string]bool)
+	}
+	s.acceptCH[origin(req.parsedUrl)] = accepted
+	s.mu.Unlock()
+
+	if req.criticalCHRetried {
+		return false
+	}
+
+	for _, name := range parseHintList(resp.Header[http.CanonicalHeaderKey("Critical-CH")]) {
+		if accepted[name] && req.HttpRequest.Header.Get(name) == "" {
+			req.criticalCHRetried = true
+			return true
+		}
+	}
+
+	return false
+}
+
+// sendWithClientHints sends req, and sends it once again with the hints
+// listed in the Critical-CH header of the response if they were missing.
+func (s *Session) sendWithClientHints(req *Request) (resp *Response, err error) {
+	for {
+		if resp, err = s.send(req); err != nil || !s.handleClientHints(req, resp) {
+			return
+		}
+
+		if s.Observer != nil {
+			s.Observer.Retried(req, "critical-ch")
+		}
+
+		_ = resp.CloseBody()
+	}
+}
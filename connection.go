@@ -4,9 +4,11 @@ import (
 	"context"
 	"crypto/x509"
 	"errors"
-	"github.com/Noooste/utls"
+	"fmt"
 	"net"
 	"time"
+
+	tls "github.com/Noooste/utls"
 )
 
 func (s *Session) dialTLS(ctx context.Context, network, addr string) (net.Conn, error) {
@@ -15,12 +17,57 @@ func (s *Session) dialTLS(ctx context.Context, network, addr string) (net.Conn,
 		return nil, errors.New("failed to dial: " + err.Error())
 	}
 
-	return s.upgradeTLS(ctx, conn, addr)
+	conn = s.observeConn(conn, addr)
+
+	tlsConn, err := s.upgradeTLS(ctx, conn, addr)
+
+	// the server rejected ECH: connect again once with the configs it sent
+	var echErr *tls.ECHRejectionError
+	if errors.As(err, &echErr) {
+		_ = conn.Close()
+
+		hostname, _, _ := net.SplitHostPort(addr)
+		s.setECHRetryConfigs(hostname, echErr.RetryConfigList)
+
+		if conn, err = s.dial(ctx, network, addr); err != nil {
+			return nil, errors.New("failed to dial: " + err.Error())
+		}
+
+		conn = s.observeConn(conn, addr)
+		tlsConn, err = s.upgradeTLS(ctx, conn, addr)
+	}
+
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if tc, ok := tlsConn.(*tls.Conn); ok {
+		markOpened(conn, tc.ConnectionState().NegotiatedProtocol)
+	}
+
+	return tlsConn, nil
+}
+
+// dialPlain establishes a cleartext connection for plain HTTP requests.
+func (s *Session) dialPlain(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := s.dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn = s.observeConn(conn, addr)
+	markOpened(conn, "")
+
+	return conn, nil
 }
 
 func (s *Session) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, _ := net.SplitHostPort(addr)
+	addr = s.connectTo(addr)
+
 	if s.Dial != nil {
-		return s.Dial(ctx, network, addr)
+		return s.Dial(ctx, network, s.overrideAddr(addr))
 	}
 
 	if s.ProxyDialer != nil {
@@ -28,7 +75,10 @@ func (s *Session) dial(ctx context.Context, network, addr string) (net.Conn, err
 		if ctx.Value(userAgentKey) != nil {
 			userAgent = ctx.Value(userAgentKey).(string)
 		}
-		conn, err := s.ProxyDialer.DialContext(ctx, userAgent, network, addr)
+		conn, err := s.ProxyDialer.DialContext(ctx, userAgent, network, s.overrideAddr(addr))
+		if err != nil && s.Observer != nil {
+			s.Observer.ProxyFailed(s.ProxyDialer.proxyLabel(), err)
+		}
 		return conn, err
 	}
 
@@ -43,8 +93,34 @@ func (s *Session) dial(ctx context.Context, network, addr string) (net.Conn, err
 		}
 	}
 
-	conn, err := dialer.DialContext(ctx, network, addr)
-	return conn, err
+	fingerprint := s.tcpFingerprint(ctx)
+	if fingerprint != nil {
+		fingerprint.apply(dialer)
+	}
+
+	addrs, err := s.resolveAddrs(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return dialRace(ctx, addrs, s.happyEyeballsDelay(), func(ctx context.Context, addr string) (net.Conn, error) {
+		d := *dialer
+		if ip := s.localAddr(host, addr); ip != nil {
+			d.LocalAddr = &net.TCPAddr{IP: ip}
+		}
+
+		done := traceConnect(ctx, network, addr)
+		conn, err := d.DialContext(ctx, network, addr)
+		done(err)
+
+		if err == nil && fingerprint != nil {
+			fingerprint.applyConn(conn)
+		}
+
+		return conn, err
+	}, func(conn net.Conn) {
+		_ = conn.Close()
+	})
 }
 
 func (s *Session) upgradeTLS(ctx context.Context, conn net.Conn, addr string) (net.Conn, error) {
@@ -57,22 +133,20 @@ func (s *Session) upgradeTLS(ctx context.Context, conn net.Conn, addr string) (n
 
 	var config tls.Config
 
+	serverName, verifyName := s.serverNames(ctx, hostname)
+
 	// Check both session-level and request-level InsecureSkipVerify
 	requestInsecureSkipVerify, _ := ctx.Value(insecureSkipVerifyKey).(bool)
 	insecureSkipVerify := s.InsecureSkipVerify || requestInsecureSkipVerify
 
 	if insecureSkipVerify {
 		config = tls.Config{
-			ServerName:         hostname,
+			ServerName:         serverName,
 			InsecureSkipVerify: true,
 		}
 	} else {
-		if err = s.PinManager.AddHost(addr, s); err != nil {
-			return nil, errors.New("failed to pin: " + err.Error())
-		}
-
 		config = tls.Config{
-			ServerName: hostname,
+			ServerName: serverName,
 			VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
 				now := time.Now()
 				for _, chain := range verifiedChains {
@@ -86,27 +160,33 @@ func (s *Session) upgradeTLS(ctx context.Context, conn net.Conn, addr string) (n
 						if cert.IsCA {
 							continue
 						}
-						if err = cert.VerifyHostname(hostname); err != nil {
+						if err = cert.VerifyHostname(verifyName); err != nil {
 							return err
 						}
 					}
 				}
 
-				pins := s.PinManager.GetHost(addr)
-				if pins == nil {
-					return errors.New("no pins found for " + addr)
-				}
+				return nil
+			},
+		}
+	}
 
-				for _, chain := range verifiedChains {
-					for _, cert := range chain {
-						if pins.Verify(cert) {
-							return nil
-						}
-					}
-				}
+	s.applyCertificateChecks(&config)
+	s.applyPins(&config, addr, verifyName)
+	s.applyRootCAs(&config, verifyName)
 
-				return errors.New("pin verification failed for " + addr)
-			},
+	config.KeyLogWriter = s.keyLogWriter()
+	config.ClientSessionCache = s.ClientSessionCache
+	config.OmitEmptyPsk = true
+	config.GetClientCertificate = s.clientCertificate(hostname)
+
+	specs := s.clientHelloSpec()
+	applyServerName(specs, serverName)
+
+	if hasECHExtension(specs) {
+		if configList := s.echConfigList(ctx, hostname); len(configList) > 0 {
+			config.EncryptedClientHelloConfigList = configList
+			config.EncryptedClientHelloRejectionVerify = s.echRejectionVerify(configList, insecureSkipVerify)
 		}
 	}
 
@@ -118,13 +198,6 @@ func (s *Session) upgradeTLS(ctx context.Context, conn net.Conn, addr string) (n
 
 	tlsConn := tls.UClient(conn, &config, tls.HelloCustom)
 
-	var fn = s.GetClientHelloSpec
-	if fn == nil {
-		fn = GetBrowserClientHelloFunc(s.Browser)
-	}
-
-	specs := fn()
-
 	if v, k := ctx.Value(forceHTTP1Key).(bool); k && v {
 		for _, ext := range specs.Extensions {
 			switch ext.(type) {
@@ -141,7 +214,7 @@ func (s *Session) upgradeTLS(ctx context.Context, conn net.Conn, addr string) (n
 	}
 
 	if err = tlsConn.Handshake(); err != nil {
-		return nil, errors.New("failed to handshake: " + err.Error())
+		return nil, fmt.Errorf("failed to handshake: %w", err)
 	}
 
 	return tlsConn.Conn, nil
@@ -0,0 +1,574 @@
+package azuretls
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	http "github.com/Noooste/fhttp"
+	"golang.org/x/net/idna"
+)
+
+// JarCookie is a cookie stored in a CookieJar, with the attributes
+// resulting from RFC 6265 processing.
+type JarCookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+
+	// Domain is the domain the cookie is sent to, without a leading dot.
+	Domain string `json:"domain"`
+	// HostOnly is true if the cookie is only sent to Domain itself, not to its subdomains.
+	HostOnly bool   `json:"host_only"`
+	Path     string `json:"path"`
+
+	// Expires is zero for session cookies.
+	Expires  time.Time `json:"expires,omitzero"`
+	Secure   bool      `json:"secure"`
+	HttpOnly bool      `json:"http_only"`
+	// SameSite is "Lax", "Strict", "None" or empty if the attribute was not set.
+	SameSite string `json:"same_site,omitempty"`
+
+	// PartitionKey is the top-level site ("https://example.com") of a cookie
+	// set with the Partitioned attribute (CHIPS), see CookiePolicy.Partitioned.
+	PartitionKey string `json:"partition_key,omitempty"`
+
+	Creation   time.Time `json:"creation"`
+	LastAccess time.Time `json:"last_access"`
+
+	seqNum uint64
+}
+
+func (c *JarCookie) id() string {
+	return c.Domain + ";" + c.Path + ";" + c.Name + ";" + c.PartitionKey
+}
+
+// sameContent reports whether c and o have the same value and attributes.
+func (c *JarCookie) sameContent(o *JarCookie) bool {
+	return c.Value == o.Value && c.Expires.Equal(o.Expires) && c.HostOnly == o.HostOnly &&
+		c.Secure == o.Secure && c.HttpOnly == o.HttpOnly && c.SameSite == o.SameSite
+}
+
+func (c *JarCookie) expired(now time.Time) bool {
+	return !c.Expires.IsZero() && !c.Expires.After(now)
+}
+
+// HttpCookie returns the cookie as sent by a server in a Set-Cookie header.
+func (c *JarCookie) HttpCookie() *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     c.Name,
+		Value:    c.Value,
+		Path:     c.Path,
+		Expires:  c.Expires,
+		Secure:   c.Secure,
+		HttpOnly: c.HttpOnly,
+		SameSite: sameSiteMode(c.SameSite),
+	}
+
+	if !c.HostOnly {
+		cookie.Domain = c.Domain
+	}
+
+	return cookie
+}
+
+func (c *JarCookie) domainMatch(host string) bool {
+	return c.Domain == host || (!c.HostOnly && hasDotSuffix(host, c.Domain))
+}
+
+func (c *JarCookie) pathMatch(path string) bool {
+	if path == c.Path {
+		return true
+	}
+
+	if strings.HasPrefix(path, c.Path) {
+		return c.Path[len(c.Path)-1] == '/' || path[len(c.Path)] == '/'
+	}
+
+	return false
+}
+
+// CookieJar is the default http.CookieJar of a Session.
+// It behaves like fhttp/cookiejar, but its content can be listed and
+// modified directly, which is needed to save and restore identities.
+type CookieJar struct {
+	mu sync.Mutex
+
+	// entries are keyed by registrable domain, then by domain;path;name
+	entries map[string]map[string]*JarCookie
+
+	nextSeqNum uint64
+}
+
+// NewCookieJar returns an empty CookieJar.
+func NewCookieJar() *CookieJar {
+	return &CookieJar{
+		entries: make(map[string]map[string]*JarCookie),
+	}
+}
+
+var (
+	errCookieDomain   = errors.New("cookiejar: illegal cookie domain attribute")
+	errCookieName     = errors.New("cookiejar: cookie name is empty")
+	errCookieNoDomain = errors.New("cookiejar: cookie domain is empty")
+)
+
+// Cookies implements the http.CookieJar interface.
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.cookies(u, nil)
+}
+
+// cookies returns the cookies to send to u, filtered by the session
+// CookiePolicy if ctx is not nil.
+func (j *CookieJar) cookies(u *url.URL, ctx *cookieContext) []*http.Cookie {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil
+	}
+
+	host, err := canonicalCookieHost(u.Host)
+	if err != nil {
+		return nil
+	}
+
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+
+	https := u.Scheme == "https"
+	now := time.Now()
+	key := cookieJarKey(host)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var selected []*JarCookie
+	for id, c := range j.entries[key] {
+		if c.expired(now) {
+			delete(j.entries[key], id)
+			continue
+		}
+
+		if !c.domainMatch(host) || !c.pathMatch(path) || (c.Secure && !https) {
+			continue
+		}
+
+		if ctx != nil && !ctx.allowSend(c) {
+			continue
+		}
+
+		c.LastAccess = now
+		selected = append(selected, c)
+	}
+
+	if len(j.entries[key]) == 0 {
+		delete(j.entries, key)
+	}
+
+	// RFC 6265 section 5.4: longest path first, then oldest first
+	sort.Slice(selected, func(a, b int) bool {
+		if len(selected[a].Path) != len(selected[b].Path) {
+			return len(selected[a].Path) > len(selected[b].Path)
+		}
+		if !selected[a].Creation.Equal(selected[b].Creation) {
+			return selected[a].Creation.Before(selected[b].Creation)
+		}
+		return selected[a].seqNum < selected[b].seqNum
+	})
+
+	cookies := make([]*http.Cookie, 0, len(selected))
+	for _, c := range selected {
+		cookies = append(cookies, &http.Cookie{
+			Name:    c.Name,
+			Value:   c.Value,
+			Path:    c.Path,
+			Domain:  c.Domain,
+			Expires: c.Expires,
+		})
+	}
+
+	return cookies
+}
+
+// SetCookies implements the http.CookieJar interface.
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.setCookies(u, cookies, nil, nil)
+}
+
+// setCookies stores cookies received from u, filtered by the session
+// CookiePolicy if ctx is not nil, and calls onChange, once the jar is
+// unlocked, for every cookie added, modified or expired.
+func (j *CookieJar) setCookies(u *url.URL, cookies []*http.Cookie, ctx *cookieContext, onChange func(cookie *JarCookie, change CookieChange)) {
+	if len(cookies) == 0 || (u.Scheme != "http" && u.Scheme != "https") {
+		return
+	}
+
+	host, err := canonicalCookieHost(u.Host)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	defPath := defaultCookiePath(u.Path)
+
+	type cookieChange struct {
+		cookie JarCookie
+		change CookieChange
+	}
+
+	var changes []cookieChange
+
+	j.mu.Lock()
+
+	for _, cookie := range cookies {
+		c, remove, err := newJarCookie(cookie, host, defPath, now)
+		if err != nil {
+			continue
+		}
+
+		if ctx != nil && !ctx.allowStore(u, cookie, c) {
+			continue
+		}
+
+		if remove {
+			if old := j.remove(c); old != nil {
+				changes = append(changes, cookieChange{*old, CookieExpired})
+			}
+			continue
+		}
+
+		old := j.store(c, now)
+		switch {
+		case old == nil:
+			changes = append(changes, cookieChange{*c, CookieAdded})
+		case !old.sameContent(c):
+			changes = append(changes, cookieChange{*c, CookieUpdated})
+		}
+	}
+
+	j.mu.Unlock()
+
+	if onChange != nil {
+		for i := range changes {
+			onChange(&changes[i].cookie, changes[i].change)
+		}
+	}
+}
+
+// All returns a copy of every cookie of the jar which has not expired,
+// sorted by domain, path and creation.
+func (j *CookieJar) All() []*JarCookie {
+	now := time.Now()
+
+	j.mu.Lock()
+
+	var cookies []*JarCookie
+	for key, submap := range j.entries {
+		for id, c := range submap {
+			if c.expired(now) {
+				delete(submap, id)
+				continue
+			}
+
+			cp := *c
+			cookies = append(cookies, &cp)
+		}
+
+		if len(submap) == 0 {
+			delete(j.entries, key)
+		}
+	}
+
+	j.mu.Unlock()
+
+	sort.Slice(cookies, func(a, b int) bool {
+		if cookies[a].Domain != cookies[b].Domain {
+			return cookies[a].Domain < cookies[b].Domain
+		}
+		if cookies[a].Path != cookies[b].Path {
+			return cookies[a].Path < cookies[b].Path
+		}
+		if !cookies[a].Creation.Equal(cookies[b].Creation) {
+			return cookies[a].Creation.Before(cookies[b].Creation)
+		}
+		return cookies[a].seqNum < cookies[b].seqNum
+	})
+
+	return cookies
+}
+
+// Set stores the cookie as is, replacing any cookie with the same name,
+// domain and path. It is meant to restore cookies listed by All: the domain
+// is not checked against a request host.
+// An expired cookie removes the stored one.
+func (j *CookieJar) Set(cookie *JarCookie) error {
+	if cookie.Name == "" {
+		return errCookieName
+	}
+
+	domain, err := canonicalCookieHost(strings.TrimPrefix(cookie.Domain, "."))
+	if err != nil {
+		return err
+	}
+
+	if domain == "" {
+		return errCookieNoDomain
+	}
+
+	c := *cookie
+	c.Domain = domain
+	if c.Path == "" || c.Path[0] != '/' {
+		c.Path = "/"
+	}
+
+	now := time.Now()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if c.expired(now) {
+		j.remove(&c)
+		return nil
+	}
+
+	j.store(&c, now)
+	return nil
+}
+
+// store must be called with j.mu held. It returns the cookie replaced by c, if any.
+func (j *CookieJar) store(c *JarCookie, now time.Time) *JarCookie {
+	key := cookieJarKey(c.Domain)
+
+	submap := j.entries[key]
+	if submap == nil {
+		submap = make(map[string]*JarCookie)
+		j.entries[key] = submap
+	}
+
+	old, ok := submap[c.id()]
+	if ok {
+		c.Creation = old.Creation
+		c.seqNum = old.seqNum
+	} else {
+		if c.Creation.IsZero() {
+			c.Creation = now
+		}
+		c.seqNum = j.nextSeqNum
+		j.nextSeqNum++
+	}
+
+	if c.LastAccess.IsZero() {
+		c.LastAccess = now
+	}
+
+	submap[c.id()] = c
+	return old
+}
+
+// remove must be called with j.mu held. It returns the removed cookie, if any.
+func (j *CookieJar) remove(c *JarCookie) *JarCookie {
+	key := cookieJarKey(c.Domain)
+
+	submap := j.entries[key]
+	old, ok := submap[c.id()]
+	if !ok {
+		return nil
+	}
+
+	delete(submap, c.id())
+	if len(submap) == 0 {
+		delete(j.entries, key)
+	}
+
+	return old
+}
+
+// Remove deletes the cookie with the given name, domain and path.
+// It reports whether the cookie was in the jar.
+func (j *CookieJar) Remove(name, domain, path string) bool {
+	domain, err := canonicalCookieHost(strings.TrimPrefix(domain, "."))
+	if err != nil {
+		return false
+	}
+
+	if path == "" {
+		path = "/"
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var removed bool
+	// a cookie may be stored once per partition
+	for _, c := range j.entries[cookieJarKey(domain)] {
+		if c.Name == name && c.Domain == domain && c.Path == path {
+			removed = j.remove(c) != nil || removed
+		}
+	}
+
+	return removed
+}
+
+// Clear deletes the cookies of domain and its subdomains, or every cookie
+// if domain is empty. It returns the number of cookies deleted.
+func (j *CookieJar) Clear(domain string) int {
+	domain, err := canonicalCookieHost(strings.TrimPrefix(domain, "."))
+	if err != nil {
+		return 0
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var n int
+	for key, submap := range j.entries {
+		for id, c := range submap {
+			if domain == "" || c.Domain == domain || hasDotSuffix(c.Domain, domain) {
+				delete(submap, id)
+				n++
+			}
+		}
+
+		if len(submap) == 0 {
+			delete(j.entries, key)
+		}
+	}
+
+	return n
+}
+
+// newJarCookie applies RFC 6265 section 5.3 to a cookie received from host.
+// remove is true if the cookie is already expired and must be deleted.
+func newJarCookie(cookie *http.Cookie, host, defPath string, now time.Time) (c *JarCookie, remove bool, err error) {
+	c = &JarCookie{
+		Name:     cookie.Name,
+		Value:    cookie.Value,
+		Path:     cookie.Path,
+		Secure:   cookie.Secure,
+		HttpOnly: cookie.HttpOnly,
+		SameSite: sameSiteName(cookie.SameSite),
+	}
+
+	if c.Path == "" || c.Path[0] != '/' {
+		c.Path = defPath
+	}
+
+	c.Domain, c.HostOnly, err = cookieDomain(host, cookie.Domain)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Max-Age takes precedence over Expires
+	switch {
+	case cookie.MaxAge < 0:
+		return c, true, nil
+	case cookie.MaxAge > 0:
+		c.Expires = now.Add(time.Duration(cookie.MaxAge) * time.Second)
+	case !cookie.Expires.IsZero():
+		if !cookie.Expires.After(now) {
+			return c, true, nil
+		}
+		c.Expires = cookie.Expires
+	}
+
+	return c, false, nil
+}
+
+// cookieDomain returns the domain of a cookie received from host and whether it is host-only.
+func cookieDomain(host, domain string) (string, bool, error) {
+	if domain == "" {
+		return host, true, nil
+	}
+
+	if net.ParseIP(host) != nil {
+		return "", false, errCookieDomain
+	}
+
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	if domain == "" || domain[0] == '.' || domain[len(domain)-1] == '.' {
+		return "", false, errCookieDomain
+	}
+
+	if host != domain && !hasDotSuffix(host, domain) {
+		return "", false, errCookieDomain
+	}
+
+	return domain, false, nil
+}
+
+// canonicalCookieHost strips the port and trailing dot of host and converts it to ASCII.
+func canonicalCookieHost(host string) (string, error) {
+	host = strings.ToLower(host)
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.TrimSuffix(host, ".")
+
+	for i := 0; i < len(host); i++ {
+		if host[i] >= 0x80 {
+			return idna.Punycode.ToASCII(host)
+		}
+	}
+
+	return host, nil
+}
+
+func sameSiteName(mode http.SameSite) string {
+	switch mode {
+	case http.SameSiteLaxMode:
+		return "Lax"
+	case http.SameSiteStrictMode:
+		return "Strict"
+	case http.SameSiteNoneMode:
+		return "None"
+	}
+	return ""
+}
+
+func sameSiteMode(name string) http.SameSite {
+	switch strings.ToLower(name) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	return 0
+}
+
+// cookieJarKey returns the last two labels of host, or host itself for IP addresses.
+func cookieJarKey(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+
+	i := strings.LastIndex(host, ".")
+	if i <= 0 {
+		return host
+	}
+
+	return host[strings.LastIndex(host[:i], ".")+1:]
+}
+
+func defaultCookiePath(path string) string {
+	if path == "" || path[0] != '/' {
+		return "/"
+	}
+
+	i := strings.LastIndex(path, "/")
+	if i == 0 {
+		return "/"
+	}
+
+	return path[:i]
+}
+
+func hasDotSuffix(s, suffix string) bool {
+	return len(s) > len(suffix) && s[len(s)-len(suffix)-1] == '.' && s[len(s)-len(suffix):] == suffix
+}
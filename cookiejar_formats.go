@@ -0,0 +1,275 @@
+package azuretls
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CookieFormat is a serialization format of the cookies of a CookieJar.
+type CookieFormat string
+
+const (
+	// CookieFormatNetscape is the cookies.txt format used by curl, wget and yt-dlp.
+	CookieFormatNetscape CookieFormat = "netscape"
+
+	// CookieFormatJSON is a JSON array of JarCookie, keeping every attribute of the jar.
+	CookieFormatJSON CookieFormat = "json"
+
+	// CookieFormatBrowser is the JSON array written by cookie export extensions
+	// (EditThisCookie, Cookie-Editor). On import, the format of Chrome DevTools
+	// Protocol (Network.getAllCookies) and Playwright/Puppeteer dumps is accepted too.
+	CookieFormatBrowser CookieFormat = "browser"
+)
+
+const netscapeHeader = "# Netscape HTTP Cookie File\n"
+
+// netscapeHttpOnlyPrefix marks HttpOnly cookies in cookies.txt files.
+const netscapeHttpOnlyPrefix = "#HttpOnly_"
+
+var errCookieFormat = errors.New("unknown cookie format")
+
+// Export writes every cookie of the jar to w in the given format.
+func (j *CookieJar) Export(w io.Writer, format CookieFormat) error {
+	cookies := j.All()
+
+	switch format {
+	case CookieFormatNetscape:
+		return writeNetscapeCookies(w, cookies)
+
+	case CookieFormatJSON:
+		if cookies == nil {
+			cookies = []*JarCookie{}
+		}
+		return json.NewEncoder(w).Encode(cookies)
+
+	case CookieFormatBrowser:
+		browserCookies := make([]*browserCookie, 0, len(cookies))
+		for _, c := range cookies {
+			browserCookies = append(browserCookies, newBrowserCookie(c))
+		}
+		return json.NewEncoder(w).Encode(browserCookies)
+	}
+
+	return errCookieFormat
+}
+
+// Import reads cookies in the given format from r and stores them in the jar,
+// replacing cookies with the same name, domain and path.
+// It returns the number of cookies read; expired cookies are counted but not stored.
+func (j *CookieJar) Import(r io.Reader, format CookieFormat) (int, error) {
+	var (
+		cookies []*JarCookie
+		err     error
+	)
+
+	switch format {
+	case CookieFormatNetscape:
+		cookies, err = readNetscapeCookies(r)
+
+	case CookieFormatJSON:
+		err = json.NewDecoder(r).Decode(&cookies)
+
+	case CookieFormatBrowser:
+		var browserCookies []*browserCookie
+		if err = json.NewDecoder(r).Decode(&browserCookies); err == nil {
+			for _, bc := range browserCookies {
+				cookies = append(cookies, bc.jarCookie())
+			}
+		}
+
+	default:
+		return 0, errCookieFormat
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	for i, c := range cookies {
+		if err = j.Set(c); err != nil {
+			return i, fmt.Errorf("cookie %d (%s): %w", i, c.Name, err)
+		}
+	}
+
+	return len(cookies), nil
+}
+
+func writeNetscapeCookies(w io.Writer, cookies []*JarCookie) error {
+	bw := bufio.NewWriter(w)
+	_, _ = bw.WriteString(netscapeHeader)
+
+	for _, c := range cookies {
+		domain := c.Domain
+		if !c.HostOnly {
+			domain = "." + domain
+		}
+
+		if c.HttpOnly {
+			domain = netscapeHttpOnlyPrefix + domain
+		}
+
+		var expires int64
+		if !c.Expires.IsZero() {
+			expires = c.Expires.Unix()
+		}
+
+		_, _ = fmt.Fprintf(bw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain, netscapeBool(!c.HostOnly), c.Path, netscapeBool(c.Secure), expires, c.Name, c.Value)
+	}
+
+	return bw.Flush()
+}
+
+func readNetscapeCookies(r io.Reader) ([]*JarCookie, error) {
+	var cookies []*JarCookie
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		httpOnly := strings.HasPrefix(line, netscapeHttpOnlyPrefix)
+		if httpOnly {
+			line = line[len(netscapeHttpOnlyPrefix):]
+		} else if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("invalid cookies.txt line %d: expected 7 fields, got %d", n, len(fields))
+		}
+
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cookies.txt line %d: %w", n, err)
+		}
+
+		c := &JarCookie{
+			Domain:   strings.TrimPrefix(fields[0], "."),
+			HostOnly: !strings.EqualFold(fields[1], "TRUE"),
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			Name:     fields[5],
+			Value:    fields[6],
+			HttpOnly: httpOnly,
+		}
+
+		if expires > 0 {
+			c.Expires = time.Unix(expires, 0)
+		}
+
+		cookies = append(cookies, c)
+	}
+
+	return cookies, scanner.Err()
+}
+
+func netscapeBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// browserCookie is a cookie exported by a browser extension or the DevTools protocol.
+type browserCookie struct {
+	Domain   string `json:"domain"`
+	HostOnly *bool  `json:"hostOnly,omitempty"`
+	Path     string `json:"path"`
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Secure   bool   `json:"secure"`
+	HttpOnly bool   `json:"httpOnly"`
+	SameSite string `json:"sameSite,omitempty"`
+	Session  bool   `json:"session"`
+	StoreId  string `json:"storeId,omitempty"`
+
+	// ExpirationDate is used by extensions, Expires by the DevTools protocol (-1 for session cookies).
+	ExpirationDate float64 `json:"expirationDate,omitempty"`
+	Expires        float64 `json:"expires,omitempty"`
+}
+
+func newBrowserCookie(c *JarCookie) *browserCookie {
+	hostOnly := c.HostOnly
+
+	bc := &browserCookie{
+		Domain:   c.Domain,
+		HostOnly: &hostOnly,
+		Path:     c.Path,
+		Name:     c.Name,
+		Value:    c.Value,
+		Secure:   c.Secure,
+		HttpOnly: c.HttpOnly,
+		Session:  c.Expires.IsZero(),
+		StoreId:  "0",
+	}
+
+	if !c.HostOnly {
+		bc.Domain = "." + c.Domain
+	}
+
+	switch c.SameSite {
+	case "Lax":
+		bc.SameSite = "lax"
+	case "Strict":
+		bc.SameSite = "strict"
+	case "None":
+		bc.SameSite = "no_restriction"
+	default:
+		bc.SameSite = "unspecified"
+	}
+
+	if !c.Expires.IsZero() {
+		bc.ExpirationDate = float64(c.Expires.UnixNano()) / float64(time.Second)
+	}
+
+	return bc
+}
+
+func (bc *browserCookie) jarCookie() *JarCookie {
+	c := &JarCookie{
+		Domain:   strings.TrimPrefix(bc.Domain, "."),
+		Path:     bc.Path,
+		Name:     bc.Name,
+		Value:    bc.Value,
+		Secure:   bc.Secure,
+		HttpOnly: bc.HttpOnly,
+	}
+
+	if bc.HostOnly != nil {
+		c.HostOnly = *bc.HostOnly
+	} else {
+		// the DevTools protocol has no hostOnly field, domain cookies have a leading dot
+		c.HostOnly = !strings.HasPrefix(bc.Domain, ".")
+	}
+
+	switch strings.ToLower(bc.SameSite) {
+	case "lax":
+		c.SameSite = "Lax"
+	case "strict":
+		c.SameSite = "Strict"
+	case "none", "no_restriction":
+		c.SameSite = "None"
+	}
+
+	expires := bc.ExpirationDate
+	if expires == 0 {
+		expires = bc.Expires
+	}
+
+	if !bc.Session && expires > 0 {
+		sec, frac := math.Modf(expires)
+		c.Expires = time.Unix(int64(sec), int64(frac*float64(time.Second)))
+	}
+
+	return c
+}
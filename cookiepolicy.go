@@ -0,0 +1,187 @@
+package azuretls
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	http "github.com/Noooste/fhttp"
+	"golang.org/x/net/publicsuffix"
+)
+
+// CookiePolicy enables browser cookie rules on top of RFC 6265 in the
+// default CookieJar. The site context of a request is given by
+// Request.Initiator and Request.Subresource.
+type CookiePolicy struct {
+	// SameSite enforces the SameSite attribute: Strict cookies are only sent
+	// on same-site requests, Lax cookies on same-site requests and cross-site
+	// top-level navigations with a safe method. Lax and Strict cookies set by
+	// cross-site subresource responses and None cookies without Secure are rejected;
+	// they are stored from any top-level navigation, whatever its method.
+	SameSite bool
+
+	// LaxByDefault treats cookies without a SameSite attribute as Lax, like Chromium.
+	LaxByDefault bool
+
+	// Partitioned stores cookies with the Partitioned attribute (CHIPS) in a
+	// partition of the top-level site, and only sends them under the same top-level site.
+	Partitioned bool
+
+	// Prefixes rejects __Secure- cookies not set with Secure from a secure origin,
+	// and __Host- cookies which also have a Domain attribute or a Path other than "/".
+	Prefixes bool
+}
+
+// BrowserCookiePolicy returns the policy of Chromium based browsers.
+func BrowserCookiePolicy() *CookiePolicy {
+	return &CookiePolicy{
+		SameSite:     true,
+		LaxByDefault: true,
+		Partitioned:  true,
+		Prefixes:     true,
+	}
+}
+
+// cookieContext is the site context of a request, used to apply a CookiePolicy.
+type cookieContext struct {
+	policy *CookiePolicy
+
+	// sameSite is true if the request is same-site with its initiator
+	sameSite bool
+	// topLevel is true for top-level navigations
+	topLevel bool
+	// navigation is true for top-level navigations with a safe method
+	navigation bool
+	// topLevelSite is the partition key of the request
+	topLevelSite string
+}
+
+// cookieContext returns the site context of req, or nil if the session has no CookiePolicy.
+func (s *Session) cookieContext(req *Request) *cookieContext {
+	if s.CookiePolicy == nil || req == nil {
+		return nil
+	}
+
+	target := req.parsedUrl
+	if target == nil && req.HttpRequest != nil {
+		target = req.HttpRequest.URL
+	}
+
+	if target == nil {
+		return nil
+	}
+
+	ctx := &cookieContext{
+		policy:       s.CookiePolicy,
+		sameSite:     true,
+		topLevel:     !req.Subresource,
+		topLevelSite: schemefulSite(target),
+	}
+
+	if req.Initiator != "" {
+		if initiator, err := url.Parse(req.Initiator); err == nil {
+			initiatorSite := schemefulSite(initiator)
+			ctx.sameSite = initiatorSite == ctx.topLevelSite
+
+			if req.Subresource {
+				ctx.topLevelSite = initiatorSite
+			}
+		}
+	}
+
+	switch strings.ToUpper(req.Method) {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		ctx.navigation = ctx.topLevel
+	}
+
+	return ctx
+}
+
+// sameSiteMode returns the SameSite attribute of c as enforced by the policy.
+func (ctx *cookieContext) sameSiteMode(sameSite string) string {
+	if sameSite == "" && ctx.policy.LaxByDefault {
+		return "Lax"
+	}
+	return sameSite
+}
+
+func (ctx *cookieContext) allowSend(c *JarCookie) bool {
+	if ctx.policy.Partitioned && c.PartitionKey != "" && c.PartitionKey != ctx.topLevelSite {
+		return false
+	}
+
+	if !ctx.policy.SameSite || ctx.sameSite {
+		return true
+	}
+
+	switch ctx.sameSiteMode(c.SameSite) {
+	case "Strict":
+		return false
+	case "Lax":
+		return ctx.navigation
+	}
+
+	return true
+}
+
+// allowStore checks cookie, received from u, against the policy and sets the
+// partition key of c.
+func (ctx *cookieContext) allowStore(u *url.URL, cookie *http.Cookie, c *JarCookie) bool {
+	secureOrigin := u.Scheme == "https"
+
+	if ctx.policy.Prefixes {
+		if strings.HasPrefix(cookie.Name, "__Secure-") && (!cookie.Secure || !secureOrigin) {
+			return false
+		}
+
+		if strings.HasPrefix(cookie.Name, "__Host-") &&
+			(!cookie.Secure || !secureOrigin || cookie.Domain != "" || cookie.Path != "/") {
+			return false
+		}
+	}
+
+	if ctx.policy.SameSite {
+		switch ctx.sameSiteMode(c.SameSite) {
+		case "None":
+			if !cookie.Secure {
+				return false
+			}
+		case "Lax", "Strict":
+			if !ctx.sameSite && !ctx.topLevel {
+				return false
+			}
+		}
+	}
+
+	if ctx.policy.Partitioned && isPartitioned(cookie) {
+		if !cookie.Secure {
+			return false
+		}
+		c.PartitionKey = ctx.topLevelSite
+	}
+
+	return true
+}
+
+// isPartitioned reports whether the Set-Cookie header had the Partitioned attribute.
+func isPartitioned(cookie *http.Cookie) bool {
+	for _, attr := range cookie.Unparsed {
+		if strings.EqualFold(strings.TrimSpace(attr), "Partitioned") {
+			return true
+		}
+	}
+	return false
+}
+
+// schemefulSite returns the scheme and registrable domain of u, e.g. "https://example.co.uk".
+func schemefulSite(u *url.URL) string {
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+
+	if net.ParseIP(host) == nil {
+		if site, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+			host = site
+		}
+	}
+
+	return strings.ToLower(u.Scheme) + "://" + host
+}
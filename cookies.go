@@ -2,8 +2,11 @@ package azuretls
 
 import (
 	"bytes"
-	http "github.com/Noooste/fhttp"
+	"errors"
+	"net/url"
 	"strings"
+
+	http "github.com/Noooste/fhttp"
 )
 
 var cookieNameSanitizer = strings.NewReplacer("\n", "-", "\r", "-")
@@ -37,3 +40,103 @@ func GetCookiesMap(cookies []*http.Cookie) map[string]string {
 
 	return result
 }
+
+// CookieChange is the kind of change reported to Session.OnCookieChange.
+type CookieChange int
+
+const (
+	// CookieAdded is reported when a Set-Cookie header stores a new cookie.
+	CookieAdded CookieChange = iota
+	// CookieUpdated is reported when a Set-Cookie header changes the value or the attributes of a stored cookie.
+	CookieUpdated
+	// CookieExpired is reported when a Set-Cookie header expires a stored cookie.
+	CookieExpired
+)
+
+func (c CookieChange) String() string {
+	switch c {
+	case CookieAdded:
+		return "added"
+	case CookieUpdated:
+		return "updated"
+	case CookieExpired:
+		return "expired"
+	}
+	return "unknown"
+}
+
+var errCookieJar = errors.New("session cookie jar is not a *CookieJar")
+
+func (s *Session) cookieJar() (*CookieJar, error) {
+	jar, ok := s.CookieJar.(*CookieJar)
+	if !ok {
+		return nil, errCookieJar
+	}
+	return jar, nil
+}
+
+// setResponseCookies stores the cookies of a response and reports the changes to OnCookieChange.
+func (s *Session) setResponseCookies(response *Response, u *url.URL, cookies []*http.Cookie) {
+	jar, ok := s.CookieJar.(*CookieJar)
+	if !ok {
+		s.CookieJar.SetCookies(u, cookies)
+		return
+	}
+
+	var onChange func(cookie *JarCookie, change CookieChange)
+	if s.OnCookieChange != nil {
+		onChange = func(cookie *JarCookie, change CookieChange) {
+			s.OnCookieChange(response, cookie, change)
+		}
+	}
+
+	jar.setCookies(u, cookies, s.cookieContext(response.Request), onChange)
+}
+
+// requestCookies returns the cookies of the jar to send with req.
+func (s *Session) requestCookies(req *Request, u *url.URL) []*http.Cookie {
+	if jar, ok := s.CookieJar.(*CookieJar); ok {
+		return jar.cookies(u, s.cookieContext(req))
+	}
+	return s.CookieJar.Cookies(u)
+}
+
+// SetCookie stores a cookie in the session, replacing any cookie with the same
+// name, domain and path. The cookie must have a domain; it is sent to
+// subdomains unless HostOnly is set.
+func (s *Session) SetCookie(cookie *JarCookie) error {
+	jar, err := s.cookieJar()
+	if err != nil {
+		return err
+	}
+	return jar.Set(cookie)
+}
+
+// DeleteCookie removes the cookie with the given name, domain and path
+// ("/" if empty) from the session. It reports whether the cookie existed.
+func (s *Session) DeleteCookie(name, domain, path string) bool {
+	jar, err := s.cookieJar()
+	if err != nil {
+		return false
+	}
+	return jar.Remove(name, domain, path)
+}
+
+// ClearCookies removes the cookies of domain and its subdomains from the
+// session, or every cookie if domain is empty. It returns the number of cookies removed.
+func (s *Session) ClearCookies(domain string) int {
+	jar, err := s.cookieJar()
+	if err != nil {
+		return 0
+	}
+	return jar.Clear(domain)
+}
+
+// AllCookies returns the cookies of every domain stored in the session.
+func (s *Session) AllCookies() []*JarCookie {
+	jar, err := s.cookieJar()
+	if err != nil {
+		return nil
+	}
+	return jar.All()
+}
@@ -0,0 +1,245 @@
+package azuretls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	tls "github.com/Noooste/utls"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ECHResolver looks up the ECHConfigList of a host, as published in the "ech"
+// parameter of its DNS HTTPS record. It returns a nil list if the host does
+// not support Encrypted Client Hello.
+type ECHResolver interface {
+	LookupECHConfigList(ctx context.Context, host string) ([]byte, error)
+}
+
+// DoHECHResolver is an ECHResolver querying the HTTPS records of hosts with
+// DNS over HTTPS (RFC 8484) through an azuretls session, like DoHResolver.
+// Results are cached for the TTL of the records.
+type DoHECHResolver struct {
+	// URL of the DoH endpoint, e.g. https://cloudflare-dns.com/dns-query.
+	URL string
+	// Session sending the queries. It must not use the DoHECHResolver itself.
+	// If nil, a new Session is used.
+	Session *Session
+
+	once  sync.Once
+	mu    sync.Mutex
+	cache map[string]echCacheEntry
+}
+
+type echCacheEntry struct {
+	configList []byte
+	expires    time.Time
+}
+
+// NewDoHECHResolver returns a DoHECHResolver querying the DoH endpoint url.
+func NewDoHECHResolver(url string) *DoHECHResolver {
+	return &DoHECHResolver{
+		URL:     url,
+		Session: NewSession(),
+		cache:   make(map[string]echCacheEntry),
+	}
+}
+
+// LookupECHConfigList implements ECHResolver.
+func (r *DoHECHResolver) LookupECHConfigList(ctx context.Context, host string) ([]byte, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	r.mu.Lock()
+	if entry, ok := r.cache[host]; ok && time.Now().Before(entry.expires) {
+		r.mu.Unlock()
+		return entry.configList, nil
+	}
+	r.mu.Unlock()
+
+	configList, ttl, err := r.query(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	if r.cache == nil {
+		r.cache = make(map[string]echCacheEntry)
+	}
+	r.cache[host] = echCacheEntry{
+		configList: configList,
+		expires:    time.Now().Add(time.Duration(ttl) * time.Second),
+	}
+	r.mu.Unlock()
+
+	return configList, nil
+}
+
+func (r *DoHECHResolver) query(ctx context.Context, host string) ([]byte, uint32, error) {
+	name, err := dnsmessage.NewName(host + ".")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: dnsmessage.TypeHTTPS, Class: dnsmessage.ClassINET},
+		},
+	}
+
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	r.once.Do(func() {
+		if r.Session == nil {
+			r.Session = NewSession()
+		}
+	})
+
+	body, err := dohExchange(ctx, r.Session, r.URL, packed)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return parseHTTPSRecords(body)
+}
+
+// parseHTTPSRecords returns the ECHConfigList of the ServiceMode HTTPS record
+// with the highest priority in msg, and the TTL of the answer.
+func parseHTTPSRecords(msg []byte) ([]byte, uint32, error) {
+	var p dnsmessage.Parser
+
+	header, err := p.Start(msg)
+	if err != nil {
+		return nil, 0, err
+	}
+	if header.RCode != dnsmessage.RCodeSuccess && header.RCode != dnsmessage.RCodeNameError {
+		return nil, 0, fmt.Errorf("DNS query failed: %s", header.RCode)
+	}
+	if err = p.SkipAllQuestions(); err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		configList []byte
+		priority   uint16
+		ttl        uint32 = 300
+	)
+
+	for {
+		h, err := p.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if h.Type != dnsmessage.TypeHTTPS {
+			if err = p.SkipAnswer(); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+
+		record, err := p.HTTPSResource()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		// priority 0 is AliasMode, which carries no parameters
+		if record.Priority == 0 {
+			continue
+		}
+
+		ech, ok := record.GetParam(dnsmessage.SVCParamECH)
+		if !ok || (configList != nil && record.Priority >= priority) {
+			continue
+		}
+
+		configList, priority, ttl = ech, record.Priority, h.TTL
+	}
+
+	return configList, ttl, nil
+}
+
+// echConfigList returns the ECHConfigList to use for host: the retry configs
+// sent by the server on a previous rejection, ECHConfigs, then ECHResolver.
+func (s *Session) echConfigList(ctx context.Context, host string) []byte {
+	s.mu.Lock()
+	configList, retried := s.echRetryConfigs[host]
+	s.mu.Unlock()
+
+	if retried {
+		return configList
+	}
+
+	if configList = s.ECHConfigs[host]; configList != nil {
+		return configList
+	}
+
+	if s.ECHResolver == nil {
+		return nil
+	}
+
+	// like browsers, connect without ECH when the lookup fails
+	configList, _ = s.ECHResolver.LookupECHConfigList(ctx, host)
+	return configList
+}
+
+// setECHRetryConfigs stores the configs the server sent when it rejected ECH,
+// an empty list disabling ECH for host.
+func (s *Session) setECHRetryConfigs(host string, configList []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.echRetryConfigs == nil {
+		s.echRetryConfigs = make(map[string][]byte)
+	}
+
+	if configList == nil {
+		configList = []byte{}
+	}
+
+	s.echRetryConfigs[host] = configList
+}
+
+// echRejectionVerify verifies the certificate of a server rejecting ECH for the
+// public name of the configs, which is the server name of the outer ClientHello.
+func (s *Session) echRejectionVerify(configList []byte, insecureSkipVerify bool) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if insecureSkipVerify {
+			return nil
+		}
+
+		configs, err := tls.UnmarshalECHConfigs(configList)
+		if err != nil {
+			return err
+		}
+
+		err = errors.New("tls: ECHConfigList has no public name")
+		for _, config := range configs {
+			if _, err = s.verifyChains(cs.PeerCertificates, string(config.Contents.PublicName)); err == nil {
+				return nil
+			}
+		}
+
+		return err
+	}
+}
+
+// hasECHExtension reports whether spec sends an encrypted_client_hello
+// extension, which utls replaces by the real one when ECH is used.
+func hasECHExtension(spec *tls.ClientHelloSpec) bool {
+	for _, ext := range spec.Extensions {
+		if _, ok := ext.(tls.EncryptedClientHelloExtension); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+package azuretls
+
+import tls "github.com/Noooste/utls"
+
+// shuffleExtensions reports whether the extensions of the ClientHello are permuted:
+// Firefox and Safari send them in a fixed order.
+func (s *Session) shuffleExtensions() bool {
+	return s.ShuffleExtensions && browserFamilyOf(s.Browser) == familyChromium
+}
+
+// clientHelloSpec returns the ClientHello of a TCP connection.
+func (s *Session) clientHelloSpec() *tls.ClientHelloSpec {
+	var fn = s.GetClientHelloSpec
+	if fn == nil {
+		fn = GetBrowserClientHelloFunc(s.Browser)
+	}
+
+	// like Chrome for every connection since Chrome 110: GREASE, padding and
+	// pre_shared_key keep their position, the JA4 fingerprint is unchanged
+	spec := fn()
+	if s.shuffleExtensions() && spec != nil {
+		spec.Extensions = tls.ShuffleChromeTLSExtensions(spec.Extensions)
+	}
+
+	return spec
+}
+
+// http3ClientHelloSpec returns the ClientHello of a QUIC connection to serverName.
+func (s *Session) http3ClientHelloSpec(serverName string) *tls.ClientHelloSpec {
+	spec := s.GetBrowserHTTP3ClientHelloFunc(s.Browser)()
+	if s.shuffleExtensions() && spec != nil {
+		spec.Extensions = tls.ShuffleChromeTLSExtensions(spec.Extensions)
+	}
+	applyServerName(spec, serverName)
+
+	return spec
+}
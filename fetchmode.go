@@ -0,0 +1,490 @@
+package azuretls
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	http "github.com/Noooste/fhttp"
+)
+
+// RequestMode describes what a browser would be doing when sending a request.
+// Setting Request.Mode (or passing a RequestMode to Get, Post, Do...) generates
+// the headers of the session browser for this kind of request, in the browser order.
+type RequestMode string
+
+const (
+	// ModeNavigate is a top-level document navigation (link, form, typed URL with
+	// Request.TypedNavigation).
+	ModeNavigate RequestMode = "navigate"
+	// ModeFetch is a fetch() or XMLHttpRequest call, same-origin or cross-site.
+	ModeFetch RequestMode = "fetch"
+	// ModeImage is an image loaded by a page.
+	ModeImage RequestMode = "image"
+	// ModeScript is a script loaded by a page.
+	ModeScript RequestMode = "script"
+	// ModeWebsocket is a websocket handshake, see NewWebsocket.
+	ModeWebsocket RequestMode = "websocket"
+)
+
+// Values of the Sec-Fetch-Site header.
+const (
+	FetchSiteNone       = "none"
+	FetchSiteSameOrigin = "same-origin"
+	FetchSiteSameSite   = "same-site"
+	FetchSiteCrossSite  = "cross-site"
+)
+
+type browserFamily int
+
+const (
+	familyChromium browserFamily = iota
+	familyFirefox
+	familySafari
+)
+
+func browserFamilyOf(browser string) browserFamily {
+	switch browser {
+	case Firefox:
+		return familyFirefox
+	case Safari, Ios:
+		return familySafari
+	}
+	return familyChromium
+}
+
+// modeHeaderOrders is the order of the headers generated for each mode. Headers
+// without a generated value (cookie, content-type...) keep their position if
+// they are set on the request.
+var modeHeaderOrders = map[browserFamily]map[RequestMode][]string{
+	familyChromium: {
+		ModeNavigate: {
+			"sec-ch-ua", "sec-ch-ua-mobile", "sec-ch-ua-platform", "origin", "content-type",
+			"upgrade-insecure-requests", "user-agent", "accept", "sec-fetch-site", "sec-fetch-mode",
+			"sec-fetch-user", "sec-fetch-dest", "referer", "accept-encoding", "accept-language", "cookie", "priority",
+		},
+		ModeFetch: {
+			"sec-ch-ua-platform", "user-agent", "sec-ch-ua", "content-type", "sec-ch-ua-mobile", "accept", "origin",
+			"sec-fetch-site", "sec-fetch-mode", "sec-fetch-dest", "referer", "accept-encoding", "accept-language",
+			"cookie", "priority",
+		},
+		ModeImage: {
+			"sec-ch-ua-platform", "user-agent", "sec-ch-ua", "sec-ch-ua-mobile", "accept", "sec-fetch-site",
+			"sec-fetch-mode", "sec-fetch-dest", "referer", "accept-encoding", "accept-language", "cookie", "priority",
+		},
+		ModeWebsocket: {
+			"pragma", "cache-control", "user-agent", "origin", "accept-encoding", "accept-language", "cookie",
+		},
+	},
+	familyFirefox: {
+		ModeNavigate: {
+			"user-agent", "accept", "accept-language", "accept-encoding", "content-type", "origin", "referer",
+			"cookie", "upgrade-insecure-requests", "sec-fetch-dest", "sec-fetch-mode", "sec-fetch-site",
+			"sec-fetch-user", "priority",
+		},
+		ModeFetch: {
+			"user-agent", "accept", "accept-language", "accept-encoding", "referer", "content-type", "origin",
+			"sec-fetch-dest", "sec-fetch-mode", "sec-fetch-site", "cookie", "priority",
+		},
+		ModeImage: {
+			"user-agent", "accept", "accept-language", "accept-encoding", "referer", "sec-fetch-dest",
+			"sec-fetch-mode", "sec-fetch-site", "cookie", "priority",
+		},
+		ModeWebsocket: {
+			"user-agent", "accept", "accept-language", "accept-encoding", "origin", "cookie",
+			"sec-fetch-dest", "sec-fetch-mode", "sec-fetch-site", "pragma", "cache-control",
+		},
+	},
+	familySafari: {
+		ModeNavigate: {
+			"accept", "content-type", "origin", "sec-fetch-site", "cookie", "sec-fetch-dest", "accept-language",
+			"sec-fetch-mode", "user-agent", "referer", "accept-encoding", "priority",
+		},
+		ModeFetch: {
+			"accept", "content-type", "sec-fetch-site", "origin", "cookie", "sec-fetch-dest", "accept-language",
+			"sec-fetch-mode", "user-agent", "referer", "accept-encoding", "priority",
+		},
+		ModeImage: {
+			"accept", "sec-fetch-site", "cookie", "sec-fetch-dest", "accept-language", "sec-fetch-mode",
+			"user-agent", "referer", "accept-encoding", "priority",
+		},
+		ModeWebsocket: {
+			"pragma", "cache-control", "user-agent", "origin", "accept-encoding", "accept-language", "cookie",
+		},
+	},
+}
+
+func init() {
+	// scripts are loaded like images, only values differ
+	for _, orders := range modeHeaderOrders {
+		orders[ModeScript] = orders[ModeImage]
+	}
+}
+
+const (
+	acceptDocumentChromium = "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7"
+	acceptDocument         = "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+	acceptImageChromium    = "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8"
+	acceptImageFirefox     = "image/avif,image/webp,image/png,image/svg+xml,image/*;q=0.8,*/*;q=0.5"
+	acceptImageSafari      = "image/webp,image/avif,image/jxl,image/heic,image/heic-sequence,video/*;q=0.8,image/png,image/svg+xml,image/*;q=0.8,*/*;q=0.5"
+)
+
+func modeAccept(family browserFamily, mode RequestMode) string {
+	switch mode {
+	case ModeNavigate:
+		if family == familyChromium {
+			return acceptDocumentChromium
+		}
+		return acceptDocument
+	case ModeImage:
+		switch family {
+		case familyFirefox:
+			return acceptImageFirefox
+		case familySafari:
+			return acceptImageSafari
+		}
+		return acceptImageChromium
+	case ModeWebsocket:
+		if family == familyFirefox {
+			return "*/*"
+		}
+		return ""
+	}
+	return "*/*"
+}
+
+var modePriorities = map[browserFamily]map[RequestMode]string{
+	familyChromium: {ModeNavigate: "u=0, i", ModeFetch: "u=1, i", ModeImage: "i", ModeScript: "u=1"},
+	familyFirefox:  {ModeNavigate: "u=0, i", ModeFetch: "u=4", ModeImage: "u=5, i", ModeScript: "u=2"},
+	familySafari:   {ModeNavigate: "u=0, i", ModeFetch: "u=3, i", ModeImage: "u=5, i", ModeScript: "u=2"},
+}
+
+func modeDestination(mode RequestMode) string {
+	switch mode {
+	case ModeNavigate:
+		return "document"
+	case ModeFetch:
+		return "empty"
+	}
+	return string(mode)
+}
+
+func modeFetchMode(mode RequestMode) string {
+	switch mode {
+	case ModeNavigate:
+		return "navigate"
+	case ModeFetch:
+		return "cors"
+	case ModeWebsocket:
+		return "websocket"
+	}
+	return "no-cors"
+}
+
+// fetchSite returns the Sec-Fetch-Site value of a request to target initiated by initiator.
+func fetchSite(initiator, target *url.URL) string {
+	switch {
+	case initiator == nil:
+		return FetchSiteNone
+	case sameOrigin(initiator, target):
+		return FetchSiteSameOrigin
+	case schemefulSite(initiator) == schemefulSite(target):
+		return FetchSiteSameSite
+	}
+	return FetchSiteCrossSite
+}
+
+// leastSameFetchSite returns the least trusted of two Sec-Fetch-Site values,
+// browsers keep it along redirect chains.
+func leastSameFetchSite(a, b string) string {
+	rank := func(site string) int {
+		switch site {
+		case FetchSiteNone:
+			return 0
+		case FetchSiteSameOrigin:
+			return 1
+		case FetchSiteSameSite:
+			return 2
+		case FetchSiteCrossSite:
+			return 3
+		}
+		return -1
+	}
+
+	if rank(a) > rank(b) {
+		return a
+	}
+	return b
+}
+
+// fetchURL returns u with the http scheme of websocket URLs, which browsers
+// use to compute the site of a websocket handshake.
+func fetchURL(u *url.URL) *url.URL {
+	var scheme string
+	switch u.Scheme {
+	case "ws":
+		scheme = SchemeHttp
+	case "wss":
+		scheme = SchemeHttps
+	default:
+		return u
+	}
+
+	c := *u
+	c.Scheme = scheme
+	return &c
+}
+
+func sameOrigin(a, b *url.URL) bool {
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Hostname(), b.Hostname()) && originPort(a) == originPort(b)
+}
+
+func originPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	return portMap[u.Scheme]
+}
+
+func origin(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
+
+// referrer returns the Referer sent from initiator to target with the
+// strict-origin-when-cross-origin policy of browsers.
+func referrer(initiator, target *url.URL) string {
+	if initiator.Scheme == SchemeHttps && target.Scheme == SchemeHttp {
+		return ""
+	}
+
+	if sameOrigin(initiator, target) {
+		ref := *initiator
+		ref.Fragment = ""
+		ref.User = nil
+		return ref.String()
+	}
+
+	return origin(initiator) + "/"
+}
+
+// requestInitiator returns the page a request of the given mode comes from:
+// Request.Initiator, then the Referer header, then the last document the
+// session navigated to. Typed navigations and the redirects of a navigation
+// without initiator have none.
+func (s *Session) requestInitiator(req *Request, headers OrderedHeaders) *url.URL {
+	for _, raw := range []string{req.Initiator, headers.Get("Referer")} {
+		if raw == "" {
+			continue
+		}
+
+		if u, err := url.Parse(raw); err == nil && u.Host != "" {
+			return u
+		}
+	}
+
+	if req.Mode == ModeNavigate && (req.TypedNavigation || req.redirectIndex > 0) {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lastDocument
+}
+
+// trackDocument keeps the final URL of a navigation as the initiator of the next requests.
+func (s *Session) trackDocument(req *Request) {
+	if req.Mode != ModeNavigate || req.parsedUrl == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.lastDocument = req.parsedUrl
+	s.mu.Unlock()
+}
+
+// requestHeaders returns the headers set on the request, either ordered
+// headers or the deprecated Header and HeaderOrder.
+func (r *Request) requestHeaders() OrderedHeaders {
+	if len(r.OrderedHeaders) > 0 {
+		return r.OrderedHeaders.Clone()
+	}
+
+	headers := make(OrderedHeaders, 0, len(r.Header))
+	seen := make(map[string]bool, len(r.Header))
+
+	add := func(key string) {
+		key = http.CanonicalHeaderKey(key)
+		if seen[key] || key == http.HeaderOrderKey || key == http.PHeaderOrderKey {
+			return
+		}
+
+		if values, ok := r.Header[key]; ok {
+			seen[key] = true
+			headers = append(headers, append([]string{key}, values...))
+		}
+	}
+
+	for _, key := range r.HeaderOrder {
+		add(key)
+	}
+
+	keys := make([]string, 0, len(r.Header))
+	for key := range r.Header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		add(key)
+	}
+
+	return headers
+}
+
+// applyRequestMode generates the browser headers of req.Mode. Headers set on
+// the request override generated values and keep the browser position;
+// other headers are sent after them.
+func (s *Session) applyRequestMode(req *Request) {
+	target := fetchURL(req.HttpRequest.URL)
+	family := browserFamilyOf(req.browser)
+
+	order, ok := modeHeaderOrders[family][req.Mode]
+	if !ok {
+		return
+	}
+
+	// keep the headers set by the user if the request is sent again
+	if req.userHeaders == nil {
+		req.userHeaders = req.requestHeaders()
+	}
+	user := req.userHeaders
+	initiator := s.requestInitiator(req, user)
+
+	site := fetchSite(initiator, target)
+	if req.fetchSite != "" {
+		site = leastSameFetchSite(req.fetchSite, site)
+	}
+	req.fetchSite = site
+
+	// keep the cookie policy consistent with the generated headers
+	req.Subresource = req.Mode != ModeNavigate
+	if req.Initiator == "" && initiator != nil {
+		req.Initiator = initiator.String()
+	}
+
+	ua := req.ua
+	if ua == "" {
+		ua = defaultUserAgent
+	}
+
+	values := map[string]string{
+		"user-agent":      ua,
+		"accept":          modeAccept(family, req.Mode),
+		"accept-encoding": "gzip, deflate, br, zstd",
+		"accept-language": "en-US,en;q=0.9",
+		"priority":        modePriorities[family][req.Mode],
+		"sec-fetch-site":  site,
+		"sec-fetch-mode":  modeFetchMode(req.Mode),
+		"sec-fetch-dest":  modeDestination(req.Mode),
+	}
+
+	switch family {
+	case familyFirefox:
+		values["accept-language"] = "en-US,en;q=0.5"
+	case familySafari:
+		values["accept-encoding"] = "gzip, deflate, br"
+	}
+
+	// high-entropy hints requested by the origin follow sec-ch-ua-platform
+	var hints []string
+	if family == familyChromium {
+		if ch := s.clientHints(ua); ch != nil {
+			for _, name := range []string{HintUA, HintMobile, HintPlatform} {
+				values[name] = ch.Header(name)
+			}
+
+			if target.Scheme == SchemeHttps {
+				accepted := s.acceptedClientHints(target)
+				for _, name := range highEntropyHints {
+					if accepted[name] {
+						hints = append(hints, name)
+						values[name] = ch.Header(name)
+					}
+				}
+			}
+		}
+	}
+
+	method := strings.ToUpper(req.Method)
+	safeMethod := method == "" || method == http.MethodGet || method == http.MethodHead
+
+	switch req.Mode {
+	case ModeNavigate:
+		values["upgrade-insecure-requests"] = "1"
+		values["sec-fetch-user"] = "?1"
+		if !safeMethod && initiator != nil {
+			values["origin"] = origin(initiator)
+		}
+	case ModeFetch:
+		if initiator != nil && (!safeMethod || !sameOrigin(initiator, target)) {
+			values["origin"] = origin(initiator)
+		}
+	case ModeWebsocket:
+		values["pragma"] = "no-cache"
+		values["cache-control"] = "no-cache"
+		if initiator != nil {
+			values["origin"] = origin(initiator)
+		} else {
+			values["origin"] = origin(target)
+		}
+	}
+
+	if initiator != nil {
+		values["referer"] = referrer(initiator, target)
+	}
+
+	names := make([]string, 0, len(order)+len(hints))
+	for _, name := range order {
+		names = append(names, name)
+		if name == HintPlatform {
+			names = append(names, hints...)
+		}
+	}
+
+	headers := make(OrderedHeaders, 0, len(names)+len(user))
+	used := make(map[string]bool, len(user))
+
+	for _, name := range names {
+		if values := user.values(name); values != nil {
+			used[name] = true
+			headers = append(headers, append([]string{name}, values...))
+			continue
+		}
+
+		if value := values[name]; value != "" {
+			headers = append(headers, []string{name, value})
+		} else if name == "cookie" {
+			// the key alone gives the position of cookies added from the jar
+			headers = append(headers, []string{name})
+		}
+	}
+
+	for _, h := range user {
+		if len(h) > 0 && !used[strings.ToLower(h[0])] {
+			headers = append(headers, h)
+		}
+	}
+
+	req.OrderedHeaders = headers
+}
+
+// values returns the values of the header named name (case-insensitive), or nil if absent.
+func (oh OrderedHeaders) values(name string) []string {
+	for _, h := range oh {
+		if len(h) > 1 && strings.EqualFold(h[0], name) {
+			return h[1:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package azuretls
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	http "github.com/Noooste/fhttp"
+	"github.com/Noooste/fhttp/http2"
+	tls "github.com/Noooste/utls"
+)
+
+// ErrInconsistentFingerprint is returned by requests of a session with
+// StrictFingerprint when ValidateFingerprint reports issues.
+var ErrInconsistentFingerprint = errors.New("azuretls: inconsistent fingerprint")
+
+// Layers of a FingerprintIssue.
+const (
+	LayerUserAgent = "user-agent"
+	LayerTLS       = "tls"
+	LayerHTTP2     = "http2"
+	LayerHTTP3     = "http3"
+	LayerHeaders   = "headers"
+)
+
+// FingerprintIssue is an inconsistency between two parts of the session
+// fingerprint, which anti-bot systems can detect.
+type FingerprintIssue struct {
+	// Layer is the part of the fingerprint contradicting the session browser or user agent.
+	Layer   string
+	Message string
+}
+
+func (i FingerprintIssue) String() string {
+	return i.Layer + ": " + i.Message
+}
+
+var familyNames = map[browserFamily]string{
+	familyChromium: "Chromium",
+	familyFirefox:  "Firefox",
+	familySafari:   "Safari",
+}
+
+// userAgentFamily returns the browser family of a user agent, or false if unknown.
+func userAgentFamily(ua string) (browserFamily, bool) {
+	switch {
+	case strings.Contains(ua, "Firefox/"):
+		return familyFirefox, true
+	case strings.Contains(ua, "CriOS/"), strings.Contains(ua, "FxiOS/"):
+		// every browser on iOS is WebKit
+		return familySafari, true
+	case strings.Contains(ua, "Chrome/"):
+		return familyChromium, true
+	case strings.Contains(ua, "Safari/") && strings.Contains(ua, "Version/"):
+		return familySafari, true
+	}
+	return 0, false
+}
+
+// helloFamily returns the browser family of a ClientHello, or false if unknown.
+func helloFamily(spec *tls.ClientHelloSpec) (family browserFamily, alps uint16, ok bool) {
+	var grease bool
+
+	for _, ext := range spec.Extensions {
+		switch ext.(type) {
+		case *tls.ApplicationSettingsExtension:
+			alps = 17513
+		case *tls.ApplicationSettingsExtensionNew:
+			alps = 17613
+		case *tls.FakeRecordSizeLimitExtension, *tls.FakeDelegatedCredentialsExtension:
+			return familyFirefox, 0, true
+		case *tls.UtlsGREASEExtension:
+			grease = true
+		}
+	}
+
+	switch {
+	case alps != 0:
+		return familyChromium, alps, true
+	case grease:
+		return familySafari, 0, true
+	}
+	return 0, 0, false
+}
+
+// expectedPseudoHeaders returns the pseudo-header order of a browser family.
+func expectedPseudoHeaders(family browserFamily) PHeader {
+	switch family {
+	case familyFirefox:
+		return PHeader{Method, Path, Authority, Scheme}
+	case familySafari:
+		return PHeader{Method, Scheme, Authority, Path}
+	}
+	return GetDefaultPseudoHeaders()
+}
+
+func formatPseudoHeaders(h []string) string {
+	short := make([]string, 0, len(h))
+	for _, el := range h {
+		if el = strings.TrimPrefix(el, ":"); el != "" {
+			short = append(short, el[:1])
+		}
+	}
+	return strings.Join(short, ",")
+}
+
+func formatSettingsOrder(order []http2.SettingID) string {
+	ids := make([]string, 0, len(order))
+	for _, id := range order {
+		ids = append(ids, strconv.Itoa(int(id)))
+	}
+	return strings.Join(ids, ",")
+}
+
+// ValidateFingerprint returns the inconsistencies between the user agent,
+// the browser, the TLS ClientHello, the HTTP/2 and HTTP/3 settings, the
+// pseudo-header order and the session headers. An empty result means no
+// inconsistency was found, not that the fingerprint is undetectable.
+func (s *Session) ValidateFingerprint() []FingerprintIssue {
+	return s.validateFingerprint(nil)
+}
+
+// validateFingerprint checks the headers of req instead of the session headers, if req is not nil.
+func (s *Session) validateFingerprint(req *Request) []FingerprintIssue {
+	var issues []FingerprintIssue
+
+	report := func(layer, format string, args ...any) {
+		issues = append(issues, FingerprintIssue{Layer: layer, Message: fmt.Sprintf(format, args...)})
+	}
+
+	browser := s.Browser
+	if browser == "" {
+		browser = Chrome
+	}
+	family := browserFamilyOf(browser)
+
+	ua := s.UserAgent
+	if ua == "" {
+		ua = defaultUserAgent
+	}
+
+	if uaFamily, ok := userAgentFamily(ua); ok && uaFamily != family {
+		report(LayerUserAgent, "user agent is %s but the browser is %s", familyNames[uaFamily], browser)
+	}
+
+	getSpec := s.GetClientHelloSpec
+	if getSpec == nil {
+		getSpec = GetBrowserClientHelloFunc(browser)
+	}
+
+	if spec := getSpec(); spec != nil {
+		if helloFamily, alps, ok := helloFamily(spec); ok {
+			if helloFamily != family {
+				report(LayerTLS, "ClientHello looks like %s but the browser is %s", familyNames[helloFamily], browser)
+			}
+
+			if m := chromiumVersionReg.FindStringSubmatch(ua); m != nil && helloFamily == familyChromium {
+				// Chrome 133 moved ALPS to the new codepoint
+				if major, _ := strconv.Atoi(m[1]); (major >= 133) != (alps == 17613) {
+					report(LayerTLS, "ClientHello uses ALPS codepoint %d, which Chrome %d does not send", alps, major)
+				}
+			}
+		}
+	}
+
+	if s.ja3 != nil && s.ja3.Navigator != "" && browserFamilyOf(s.ja3.Navigator) != family {
+		report(LayerTLS, "JA3 was applied for %s but the browser is %s", s.ja3.Navigator, browser)
+	}
+
+	if tr := s.HTTP2Transport; tr != nil && len(tr.SettingsOrder) > 0 {
+		_, expected := defaultHeaderSettings(browser)
+		if got := formatSettingsOrder(tr.SettingsOrder); got != formatSettingsOrder(expected) {
+			report(LayerHTTP2, "SETTINGS order %s does not match %s (%s)", got, browser, formatSettingsOrder(expected))
+		}
+	}
+
+	expectedPHeader := expectedPseudoHeaders(family)
+
+	pHeader := s.PHeader
+	if req != nil && req.PHeader != nil {
+		pHeader = req.PHeader
+	}
+
+	if got, expected := formatPseudoHeaders(pHeader), formatPseudoHeaders(expectedPHeader); got != "" && got != expected {
+		report(LayerHTTP2, "pseudo-header order %s does not match %s (%s)", got, browser, expected)
+	}
+
+	if s.HTTP3Config != nil && s.HTTP3Config.Enabled && family != familyChromium && s.GetClientHelloSpecHTTP3 == nil {
+		report(LayerHTTP3, "HTTP/3 is enabled but there is no HTTP/3 profile for %s", browser)
+	}
+
+	checkHeaders := func(headers http.Header) {
+		if v := headers.Get("User-Agent"); v != "" && v != ua {
+			report(LayerHeaders, "User-Agent header %q differs from the session user agent", v)
+		}
+
+		secChUa := headers.Get(HintUA)
+		if secChUa == "" {
+			return
+		}
+
+		ch := s.clientHints(ua)
+		if ch == nil || family != familyChromium {
+			report(LayerHeaders, "%s header is sent by a browser which does not send client hints", HintUA)
+			return
+		}
+
+		for _, name := range []string{HintUA, HintMobile, HintPlatform} {
+			if v := headers.Get(name); v != "" && v != ch.Header(name) {
+				report(LayerHeaders, "%s header %s does not match the user agent (%s)", name, v, ch.Header(name))
+			}
+		}
+	}
+
+	// the headers of a request include the session headers
+	orderedHeaders, header := s.OrderedHeaders, s.Header
+	if req != nil {
+		orderedHeaders, header = req.OrderedHeaders, req.Header
+	}
+
+	if len(orderedHeaders) > 0 {
+		checkHeaders(orderedHeaders.ToHeader())
+	} else if header != nil {
+		checkHeaders(header)
+	}
+
+	return issues
+}
+
+// checkFingerprint returns ErrInconsistentFingerprint with the issues of req
+// if the session has StrictFingerprint.
+func (s *Session) checkFingerprint(req *Request) error {
+	if !s.StrictFingerprint {
+		return nil
+	}
+
+	issues := s.validateFingerprint(req)
+	if len(issues) == 0 {
+		return nil
+	}
+
+	messages := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		messages = append(messages, issue.String())
+	}
+
+	return fmt.Errorf("%w: %s", ErrInconsistentFingerprint, strings.Join(messages, "; "))
+}
@@ -0,0 +1,415 @@
+package azuretls
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	http "github.com/Noooste/fhttp"
+	"github.com/Noooste/fhttp/http2"
+	"github.com/Noooste/fhttp/http2/hpack"
+	quic "github.com/Noooste/uquic-go"
+	h3qlog "github.com/Noooste/uquic-go/http3/qlog"
+	"github.com/Noooste/uquic-go/qlogwriter"
+	"github.com/Noooste/uquic-go/qlogwriter/jsontext"
+	tls "github.com/Noooste/utls"
+)
+
+// Directions of a frame in the frame log.
+const (
+	FrameSent     = "sent"
+	FrameReceived = "recv"
+)
+
+// http2ClientPreface is written by the client before its first frame.
+const http2ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+var frameLogConnID atomic.Uint64
+
+// FrameRecord is a line of the frame log written to Session.FrameLogWriter.
+//
+// HTTP/2 frames are decoded by the session (HEADERS and CONTINUATION are
+// reported once the header block is complete, with HPACK-decoded fields in
+// wire order). HTTP/3 records carry the qlog event emitted by the QUIC stack
+// in Event and Data, including QPACK-decoded header fields.
+type FrameRecord struct {
+	Time      time.Time `json:"time"`
+	Conn      uint64    `json:"conn"`
+	Proto     string    `json:"proto"`
+	Authority string    `json:"authority,omitempty"`
+	Direction string    `json:"dir,omitempty"`
+
+	Type     string `json:"type,omitempty"`
+	StreamID uint32 `json:"stream"`
+	Flags    uint8  `json:"flags"`
+	Length   uint32 `json:"length"`
+
+	Settings     []FrameSetting  `json:"settings,omitempty"`
+	Ack          bool            `json:"ack,omitempty"`
+	Increment    uint32          `json:"increment,omitempty"`
+	Priority     *FramePriority  `json:"priority,omitempty"`
+	Headers      [][2]string     `json:"headers,omitempty"`
+	EndStream    bool            `json:"end_stream,omitempty"`
+	ErrorCode    string          `json:"error_code,omitempty"`
+	LastStreamID uint32          `json:"last_stream_id,omitempty"`
+	DebugData    string          `json:"debug_data,omitempty"`
+	Event        string          `json:"event,omitempty"`
+	Data         json.RawMessage `json:"data,omitempty"`
+	Error        string          `json:"error,omitempty"`
+}
+
+// FrameSetting is a SETTINGS parameter of a FrameRecord.
+type FrameSetting struct {
+	ID    uint16 `json:"id"`
+	Value uint32 `json:"value"`
+}
+
+// FramePriority is the priority information of a PRIORITY or HEADERS frame.
+type FramePriority struct {
+	StreamDep uint32 `json:"stream_dep"`
+	Exclusive bool   `json:"exclusive"`
+	Weight    uint16 `json:"weight"`
+}
+
+// writeFrameRecord writes the record as a JSON line, serialized with other connections of the session.
+func (s *Session) writeFrameRecord(record *FrameRecord) {
+	w := s.FrameLogWriter
+	if w == nil {
+		return
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	s.frameLogMu.Lock()
+	_, _ = w.Write(line)
+	s.frameLogMu.Unlock()
+}
+
+// installFrameLog wraps the HTTP/2 upgrade of the HTTP/1 transport so that
+// connections are decoded when Session.FrameLogWriter is set.
+func (s *Session) installFrameLog(t1 *http.Transport, t2 *http2.Transport) {
+	upgrade := t1.TLSNextProto[http2.NextProtoTLS]
+	if upgrade == nil {
+		return
+	}
+
+	t1.TLSNextProto[http2.NextProtoTLS] = func(authority string, c *tls.Conn) http.RoundTripper {
+		if s.FrameLogWriter == nil {
+			return upgrade(authority, c)
+		}
+
+		cc, err := t2.NewClientConn(s.newFrameLogConn(c, authority, t2))
+		if err != nil {
+			go c.Close()
+			return erringRoundTripper{err}
+		}
+
+		return &frameLogRoundTripper{cc: cc}
+	}
+}
+
+// frameLogRoundTripper sends requests on a single logged HTTP/2 connection.
+type frameLogRoundTripper struct {
+	cc *http2.ClientConn
+}
+
+func (rt *frameLogRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !rt.cc.CanTakeNewRequest() {
+		// let the HTTP/1 transport drop this connection and dial a new one
+		return nil, noCachedConnError{}
+	}
+
+	return rt.cc.RoundTrip(req)
+}
+
+type noCachedConnError struct{}
+
+func (noCachedConnError) IsHTTP2NoCachedConnError() {}
+func (noCachedConnError) Error() string             { return "http2: no cached connection was available" }
+
+type erringRoundTripper struct{ err error }
+
+func (rt erringRoundTripper) RoundTrip(*http.Request) (*http.Response, error) { return nil, rt.err }
+
+// frameLogConn decodes the HTTP/2 frames going through a TLS connection.
+type frameLogConn struct {
+	net.Conn
+
+	sent *h2FrameDecoder
+	recv *h2FrameDecoder
+}
+
+func (s *Session) newFrameLogConn(c net.Conn, authority string, t2 *http2.Transport) net.Conn {
+	id := frameLogConnID.Add(1)
+
+	recvTableSize := t2.HeaderTableSize
+	if recvTableSize == 0 {
+		recvTableSize = 4096
+	}
+
+	return &frameLogConn{
+		Conn: c,
+		sent: newH2FrameDecoder(s, id, authority, FrameSent, 4096, len(http2ClientPreface)),
+		recv: newH2FrameDecoder(s, id, authority, FrameReceived, recvTableSize, 0),
+	}
+}
+
+func (c *frameLogConn) Read(p []byte) (n int, err error) {
+	n, err = c.Conn.Read(p)
+	if n > 0 {
+		c.recv.feed(p[:n])
+	}
+	return
+}
+
+func (c *frameLogConn) Write(p []byte) (n int, err error) {
+	n, err = c.Conn.Write(p)
+	if n > 0 {
+		c.sent.feed(p[:n])
+	}
+	return
+}
+
+// h2FrameDecoder decodes one direction of an HTTP/2 connection.
+type h2FrameDecoder struct {
+	s         *Session
+	conn      uint64
+	authority string
+	dir       string
+
+	skip    int
+	buf     bytes.Buffer
+	framer  *http2.Framer
+	decoder *hpack.Decoder
+
+	pending *FrameRecord
+	failed  bool
+}
+
+func newH2FrameDecoder(s *Session, conn uint64, authority, dir string, tableSize uint32, skip int) *h2FrameDecoder {
+	d := &h2FrameDecoder{
+		s:         s,
+		conn:      conn,
+		authority: authority,
+		dir:       dir,
+		skip:      skip,
+	}
+
+	d.framer = http2.NewFramer(io.Discard, &d.buf)
+	d.framer.SetMaxReadFrameSize(1<<24 - 1)
+	d.framer.AllowIllegalReads = true
+
+	d.decoder = hpack.NewDecoder(tableSize, func(f hpack.HeaderField) {
+		if d.pending != nil {
+			d.pending.Headers = append(d.pending.Headers, [2]string{f.Name, f.Value})
+		}
+	})
+	d.decoder.SetAllowedMaxDynamicTableSize(math.MaxUint32)
+
+	return d
+}
+
+func (d *h2FrameDecoder) feed(p []byte) {
+	if d.failed {
+		return
+	}
+
+	if d.skip > 0 {
+		n := min(d.skip, len(p))
+		d.skip -= n
+		p = p[n:]
+	}
+
+	d.buf.Write(p)
+
+	for d.buf.Len() >= 9 {
+		b := d.buf.Bytes()
+		length := int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+		if d.buf.Len() < 9+length {
+			return
+		}
+
+		f, err := d.framer.ReadFrame()
+		if err != nil {
+			// the stream cannot be resynchronized, stop decoding this direction
+			d.failed = true
+			d.write(&FrameRecord{Error: err.Error()})
+			return
+		}
+
+		d.handle(f)
+	}
+}
+
+func (d *h2FrameDecoder) write(r *FrameRecord) {
+	r.Time = time.Now()
+	r.Conn = d.conn
+	r.Proto = ProtoHTTP2
+	r.Authority = d.authority
+	r.Direction = d.dir
+	d.s.writeFrameRecord(r)
+}
+
+func (d *h2FrameDecoder) handle(f http2.Frame) {
+	h := f.Header()
+	r := &FrameRecord{
+		Type:     h.Type.String(),
+		StreamID: h.StreamID,
+		Flags:    uint8(h.Flags),
+		Length:   h.Length,
+	}
+
+	switch f := f.(type) {
+	case *http2.SettingsFrame:
+		r.Ack = f.IsAck()
+		_ = f.ForeachSetting(func(setting http2.Setting) error {
+			r.Settings = append(r.Settings, FrameSetting{ID: uint16(setting.ID), Value: setting.Val})
+			return nil
+		})
+
+	case *http2.WindowUpdateFrame:
+		r.Increment = f.Increment
+
+	case *http2.PriorityFrame:
+		r.Priority = newFramePriority(f.PriorityParam)
+
+	case *http2.HeadersFrame:
+		if f.HasPriority() {
+			r.Priority = newFramePriority(f.Priority)
+		}
+		r.EndStream = f.StreamEnded()
+
+		d.pending = r
+		d.decodeHeaderBlock(f.HeaderBlockFragment(), f.HeadersEnded())
+		return
+
+	case *http2.ContinuationFrame:
+		if d.pending != nil {
+			d.pending.Length += h.Length
+			d.decodeHeaderBlock(f.HeaderBlockFragment(), f.HeadersEnded())
+			return
+		}
+
+	case *http2.DataFrame:
+		r.EndStream = f.StreamEnded()
+
+	case *http2.RSTStreamFrame:
+		r.ErrorCode = f.ErrCode.String()
+
+	case *http2.GoAwayFrame:
+		r.LastStreamID = f.LastStreamID
+		r.ErrorCode = f.ErrCode.String()
+		r.DebugData = string(f.DebugData())
+
+	case *http2.PingFrame:
+		r.Ack = f.IsAck()
+	}
+
+	d.write(r)
+}
+
+func (d *h2FrameDecoder) decodeHeaderBlock(fragment []byte, ended bool) {
+	if _, err := d.decoder.Write(fragment); err != nil {
+		d.pending.Error = err.Error()
+	}
+
+	if !ended {
+		return
+	}
+
+	if err := d.decoder.Close(); err != nil && d.pending.Error == "" {
+		d.pending.Error = err.Error()
+	}
+
+	r := d.pending
+	d.pending = nil
+	d.write(r)
+}
+
+func newFramePriority(p http2.PriorityParam) *FramePriority {
+	return &FramePriority{
+		StreamDep: p.StreamDep,
+		Exclusive: p.Exclusive,
+		// the weight is sent minus one on the wire
+		Weight: uint16(p.Weight) + 1,
+	}
+}
+
+type frameLogAuthorityKey struct{}
+
+// quicTracer returns the qlog trace used to log HTTP/3 frames of a QUIC connection.
+func (s *Session) quicTracer(ctx context.Context, _ bool, _ quic.ConnectionID) qlogwriter.Trace {
+	if s.FrameLogWriter == nil {
+		return nil
+	}
+
+	authority, _ := ctx.Value(frameLogAuthorityKey{}).(string)
+
+	return &h3FrameTrace{
+		s:         s,
+		conn:      frameLogConnID.Add(1),
+		authority: authority,
+	}
+}
+
+// h3FrameTrace writes the HTTP/3 qlog events of a connection to the frame log.
+type h3FrameTrace struct {
+	s         *Session
+	conn      uint64
+	authority string
+}
+
+func (t *h3FrameTrace) SupportsSchemas(schema string) bool {
+	return schema == h3qlog.EventSchema
+}
+
+func (t *h3FrameTrace) AddProducer() qlogwriter.Recorder {
+	return t
+}
+
+func (t *h3FrameTrace) Close() error {
+	return nil
+}
+
+func (t *h3FrameTrace) RecordEvent(ev qlogwriter.Event) {
+	name := ev.Name()
+	if !strings.HasPrefix(name, "http3:") {
+		// QUIC transport events are not part of the frame log
+		return
+	}
+
+	now := time.Now()
+
+	var buf bytes.Buffer
+	if err := ev.Encode(jsontext.NewEncoder(&buf), now); err != nil {
+		return
+	}
+
+	r := &FrameRecord{
+		Time:      now,
+		Conn:      t.conn,
+		Proto:     ProtoHTTP3,
+		Authority: t.authority,
+		Event:     name,
+		Data:      bytes.TrimSpace(buf.Bytes()),
+	}
+
+	switch name {
+	case "http3:frame_created":
+		r.Direction = FrameSent
+	case "http3:frame_parsed":
+		r.Direction = FrameReceived
+	}
+
+	t.s.writeFrameRecord(r)
+}
@@ -0,0 +1,179 @@
+package azuretls
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/Noooste/fhttp/httptrace"
+)
+
+// AddressFamily selects the IP versions of the addresses connections are made to.
+type AddressFamily int
+
+const (
+	// PreferIPv6 races the addresses of hosts starting with IPv6, as RFC 8305 recommends.
+	PreferIPv6 AddressFamily = iota
+	// PreferIPv4 races the addresses of hosts starting with IPv4.
+	PreferIPv4
+	// IPv4Only only connects to IPv4 addresses.
+	IPv4Only
+	// IPv6Only only connects to IPv6 addresses.
+	IPv6Only
+)
+
+// defaultHappyEyeballsDelay is the Connection Attempt Delay recommended by RFC 8305.
+const defaultHappyEyeballsDelay = 250 * time.Millisecond
+
+// happyEyeballsDelay returns the delay between two connection attempts, negative
+// if an attempt only starts once the previous one failed.
+func (s *Session) happyEyeballsDelay() time.Duration {
+	if s.HappyEyeballsDelay == 0 {
+		return defaultHappyEyeballsDelay
+	}
+	return s.HappyEyeballsDelay
+}
+
+// ipNetwork returns the network of the lookup of the addresses of network,
+// restricted by the AddressFamily of the session.
+func (s *Session) ipNetwork(network string) string {
+	switch {
+	case len(network) > 0 && network[len(network)-1] == '4':
+		return "ip4"
+	case len(network) > 0 && network[len(network)-1] == '6':
+		return "ip6"
+	case s.AddressFamily == IPv4Only:
+		return "ip4"
+	case s.AddressFamily == IPv6Only:
+		return "ip6"
+	}
+	return "ip"
+}
+
+// sortIPs interleaves the IPv6 and IPv4 addresses of ips, starting with the
+// family preferred by the session (RFC 8305 section 4).
+func (s *Session) sortIPs(ips []net.IP) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+
+	first, second := v6, v4
+	if s.AddressFamily == PreferIPv4 {
+		first, second = v4, v6
+	}
+
+	sorted := make([]net.IP, 0, len(ips))
+	for len(first) > 0 || len(second) > 0 {
+		if len(first) > 0 {
+			sorted = append(sorted, first[0])
+			first = first[1:]
+		}
+		if len(second) > 0 {
+			sorted = append(sorted, second[0])
+			second = second[1:]
+		}
+	}
+
+	return sorted
+}
+
+// dialRace connects to the first reachable address of addrs like RFC 8305:
+// an attempt starts every delay, or as soon as the previous one failed, and
+// the first connection established wins, the others being closed.
+func dialRace[C any](ctx context.Context, addrs []string, delay time.Duration, dial func(ctx context.Context, addr string) (C, error), closeConn func(C)) (C, error) {
+	var zero C
+
+	if len(addrs) == 1 {
+		return dial(ctx, addrs[0])
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn C
+		err  error
+	}
+
+	var (
+		results  = make(chan result, len(addrs))
+		next     int
+		pending  int
+		timer    <-chan time.Time
+		firstErr error
+	)
+
+	start := func() {
+		addr := addrs[next]
+		next++
+		pending++
+
+		go func() {
+			conn, err := dial(ctx, addr)
+			results <- result{conn, err}
+		}()
+
+		timer = nil
+		if delay >= 0 && next < len(addrs) {
+			timer = time.After(delay)
+		}
+	}
+
+	start()
+
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+
+			if r.err == nil {
+				// close the connections of the attempts still running
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if r := <-results; r.err == nil {
+							closeConn(r.conn)
+						}
+					}
+				}(pending)
+
+				return r.conn, nil
+			}
+
+			if firstErr == nil {
+				firstErr = r.err
+			}
+
+			if next < len(addrs) && ctx.Err() == nil {
+				start()
+			}
+
+		case <-timer:
+			start()
+		}
+	}
+
+	return zero, firstErr
+}
+
+// traceConnect reports a connection attempt to addr to the ClientTrace of ctx.
+func traceConnect(ctx context.Context, network, addr string) func(err error) {
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace == nil {
+		return func(error) {}
+	}
+
+	if trace.ConnectStart != nil {
+		trace.ConnectStart(network, addr)
+	}
+
+	return func(err error) {
+		if trace.ConnectDone != nil {
+			trace.ConnectDone(network, addr, err)
+		}
+	}
+}
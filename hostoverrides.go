@@ -0,0 +1,87 @@
+package azuretls
+
+import (
+	"net"
+	"strings"
+)
+
+// connectTo returns the authority the connections to addr are made to,
+// see Session.ConnectTo.
+func (s *Session) connectTo(addr string) string {
+	if len(s.ConnectTo) == 0 {
+		return addr
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	target, ok := lookupHostMap(s.ConnectTo, host, port)
+	if !ok {
+		return addr
+	}
+
+	targetHost, targetPort, err := net.SplitHostPort(target)
+	if err != nil {
+		// a host without port
+		targetHost, targetPort = strings.Trim(target, "[]"), ""
+	}
+
+	if targetHost == "" {
+		targetHost = host
+	}
+	if targetPort == "" {
+		targetPort = port
+	}
+
+	return net.JoinHostPort(targetHost, targetPort)
+}
+
+// hostOverrides returns the addresses of addr in Session.HostOverrides, or nil.
+func (s *Session) hostOverrides(addr string) []string {
+	if len(s.HostOverrides) == 0 {
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+
+	overrides, ok := lookupHostMap(s.HostOverrides, host, port)
+	if !ok || len(overrides) == 0 {
+		return nil
+	}
+
+	addrs := make([]string, len(overrides))
+	for i, override := range overrides {
+		if _, _, err = net.SplitHostPort(override); err != nil {
+			override = net.JoinHostPort(strings.Trim(override, "[]"), port)
+		}
+		addrs[i] = override
+	}
+
+	return addrs
+}
+
+// overrideAddr returns the first address of addr in Session.HostOverrides, or
+// addr, for the dials resolving addr themselves, like proxies.
+func (s *Session) overrideAddr(addr string) string {
+	if addrs := s.hostOverrides(addr); addrs != nil {
+		return addrs[0]
+	}
+	return addr
+}
+
+// lookupHostMap returns the value of host:port in m, or of host for any port.
+func lookupHostMap[V any](m map[string]V, host, port string) (V, bool) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	if v, ok := m[net.JoinHostPort(host, port)]; ok {
+		return v, true
+	}
+
+	v, ok := m[host]
+	return v, ok
+}
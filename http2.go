@@ -73,6 +73,7 @@ func (s *Session) ApplyHTTP2(fp string) error {
 		return err
 	}
 
+	s.http2Fingerprint = fp
 	return nil
 }
 
@@ -37,6 +37,11 @@ type HTTP3Config struct {
 	// Force HTTP/3 for all requests (no fallback)
 	ForceHTTP3 bool
 
+	// Allow0RTT sends GET and HEAD requests in 0-RTT on connections resuming a
+	// TLS session of the session's ClientSessionCache, like Chrome. 0-RTT
+	// requests can be replayed by an attacker.
+	Allow0RTT bool
+
 	// Alt-Svc cache for HTTP/3 discovery
 	altSvcCache sync.Map
 
@@ -48,6 +53,9 @@ type HTTP3Config struct {
 func (s *Session) NewHTTP3Transport() (*HTTP3Transport, error) {
 	tlsConfig := &tls.Config{
 		InsecureSkipVerify: s.InsecureSkipVerify,
+		KeyLogWriter:       s.keyLogWriter(),
+		ClientSessionCache: quicSessionCache(s.ClientSessionCache),
+		OmitEmptyPsk:       true,
 	}
 
 	quicConfig := &quic.Config{
@@ -57,6 +65,7 @@ func (s *Session) NewHTTP3Transport() (*HTTP3Transport, error) {
 		InitialStreamReceiveWindow:     512 * 1024,
 		InitialConnectionReceiveWindow: 1024 * 1024,
 		EnableDatagrams:                true,
+		Tracer:                         s.quicTracer,
 	}
 
 	settings, order := defaultHTTP3Settings(s.Browser)
@@ -100,6 +109,24 @@ func (t *HTTP3Transport) Close() error {
 
 // RoundTrip implements the http.RoundTripper interface with proxy support
 func (t *HTTP3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if config := t.sess.HTTP3Config; config != nil && config.Allow0RTT {
+		// the 0-RTT methods are sent without waiting for the handshake
+		early := *req
+		switch req.Method {
+		case http.MethodGet:
+			early.Method = http3.MethodGet0RTT
+		case http.MethodHead:
+			early.Method = http3.MethodHead0RTT
+		}
+
+		if early.Method != req.Method {
+			resp, err := t.Transport.RoundTrip(&early)
+			if !errors.Is(err, quic.Err0RTTRejected) {
+				return resp, err
+			}
+		}
+	}
+
 	// Direct connection
 	return t.Transport.RoundTrip(req)
 }
@@ -222,8 +249,19 @@ func contains(str, substr string) bool {
 
 // dialQUIC establishes a QUIC connection
 func (s *Session) dialQUIC(ctx context.Context, addr string, tlsConf *tls.Config, quicConf *quic.Config) (*quic.Conn, error) {
+	ctx = context.WithValue(ctx, frameLogAuthorityKey{}, addr)
+
+	// tlsConf is a copy made for this connection by http3.Transport
+	hostname := tlsConf.ServerName
+
+	var verifyName string
+	tlsConf.ServerName, verifyName = s.serverNames(ctx, hostname)
+	tlsConf.GetClientCertificate = s.clientCertificate(hostname)
+	s.applyCertificateChecks(tlsConf)
+	s.applyRootCAs(tlsConf, verifyName)
+
 	// Resolve address
-	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	addrs, err := s.resolveAddrs(ctx, "udp", s.connectTo(addr))
 	if err != nil {
 		return nil, err
 	}
@@ -236,11 +274,54 @@ func (s *Session) dialQUIC(ctx context.Context, addr string, tlsConf *tls.Config
 
 	// Handle proxy if configured
 	if s.ProxyDialer != nil {
-		return s.dialQUICViaProxy(ctx, udpAddr, tlsConf, quicConf)
+		udpAddr, err := net.ResolveUDPAddr("udp", addrs[0])
+		if err != nil {
+			return nil, err
+		}
+
+		conn, err := s.dialQUICViaProxy(ctx, udpAddr, tlsConf, quicConf)
+		if err != nil {
+			if s.Observer != nil {
+				s.Observer.ProxyFailed(s.ProxyDialer.proxyLabel(), err)
+			}
+			return nil, err
+		}
+
+		s.observeQUIC(conn, addr)
+		return conn, nil
+	}
+
+	conn, err := dialRace(ctx, addrs, s.happyEyeballsDelay(), func(ctx context.Context, udpAddr string) (*quic.Conn, error) {
+		done := traceConnect(ctx, "udp", udpAddr)
+		conn, err := s.dialQUICAddr(ctx, hostname, udpAddr, tlsConf.Clone(), quicConf)
+		done(err)
+		return conn, err
+	}, func(conn *quic.Conn) {
+		_ = conn.CloseWithError(0, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.observeQUIC(conn, addr)
+
+	return conn, nil
+}
+
+// dialQUICAddr establishes a QUIC connection to host at the address addr.
+func (s *Session) dialQUICAddr(ctx context.Context, host, addr string, tlsConf *tls.Config, quicConf *quic.Config) (*quic.Conn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	localIP := net.IPv4zero
+	if ip := s.localAddr(host, addr); ip != nil {
+		localIP = ip
 	}
 
 	// Create UDP connection
-	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP, Port: 0})
 	if err != nil {
 		return nil, err
 	}
@@ -250,7 +331,7 @@ func (s *Session) dialQUIC(ctx context.Context, addr string, tlsConf *tls.Config
 			Conn: udpConn,
 		},
 		QUICSpec: &quic.QUICSpec{
-			ClientHelloSpec:   s.GetBrowserHTTP3ClientHelloFunc(s.Browser)(),
+			ClientHelloSpec:   s.http3ClientHelloSpec(tlsConf.ServerName),
 			InitialPacketSpec: getInitialPacket(s.Browser),
 		},
 	}
@@ -270,6 +351,21 @@ func (s *Session) dialQUIC(ctx context.Context, addr string, tlsConf *tls.Config
 	return conn, nil
 }
 
+// observeQUIC reports the lifecycle of a QUIC connection to the session Observer.
+func (s *Session) observeQUIC(conn *quic.Conn, addr string) {
+	if s.Observer == nil {
+		return
+	}
+
+	observer := s.Observer
+	observer.ConnOpened(addr, ProtoHTTP3)
+
+	go func() {
+		<-conn.Context().Done()
+		observer.ConnClosed(addr, ProtoHTTP3)
+	}()
+}
+
 // dialQUICViaProxy establishes a QUIC connection through a proxy
 func (s *Session) dialQUICViaProxy(ctx context.Context, remoteAddr *net.UDPAddr, tlsConf *tls.Config, quicConf *quic.Config) (*quic.Conn, error) {
 	return s.dialQUICViaSocks5(ctx, remoteAddr, tlsConf, quicConf)
@@ -312,6 +408,7 @@ func (s *Session) ApplyHTTP3(fp string) error {
 	s.HTTP3Config.transport.AdditionalSettingsOrder = settingsOrder
 
 	s.PHeader = pseudoHeaders
+	s.http3Fingerprint = fp
 	return nil
 }
 
@@ -133,6 +133,12 @@ func (s *Session) ApplyJa3WithSpecifications(ja3 string, specifications *TlsSpec
 		specs, _ := stringToSpec(ja3, specifications, navigator)
 		return specs
 	}
+
+	s.ja3 = &appliedJa3{
+		Fingerprint:    ja3,
+		Navigator:      navigator,
+		Specifications: specifications,
+	}
 	return nil
 }
 
@@ -379,8 +385,8 @@ func getExtensions(extensions []string, specifications *TlsSpecifications, defau
 			break
 
 		case "41":
-			builtExtensions = append(builtExtensions, &tls.GenericExtension{Id: 41})
-			break
+			// only sent when a session is resumed
+			builtExtensions = append(builtExtensions, &tls.UtlsPreSharedKeyExtension{})
 
 		case "43":
 			var supportedVersions []uint16
@@ -488,18 +494,14 @@ func getExtensions(extensions []string, specifications *TlsSpecifications, defau
 	}
 
 	if navigator == Chrome {
-		lastIndex := len(extensions) - 1
-		last := extensions[lastIndex]
-
-		switch last {
-		case "21":
-			lastIndex := len(builtExtensions) - 1
-			last := builtExtensions[lastIndex]
-			builtExtensions[lastIndex] = &tls.UtlsGREASEExtension{}
-			builtExtensions = append(builtExtensions, last)
-		default:
-			builtExtensions = append(builtExtensions, &tls.UtlsGREASEExtension{})
+		// the last GREASE extension comes before padding and pre_shared_key
+		trailing := 0
+		for i := len(extensions) - 1; i >= 0 && (extensions[i] == "21" || extensions[i] == "41"); i-- {
+			trailing++
 		}
+
+		index := len(builtExtensions) - trailing
+		builtExtensions = append(builtExtensions[:index], append([]tls.TLSExtension{&tls.UtlsGREASEExtension{}}, builtExtensions[index:]...)...)
 	}
 
 	return builtExtensions, minVers, maxVers, nil
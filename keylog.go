@@ -0,0 +1,64 @@
+package azuretls
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// KeyLogEnv is the environment variable holding the path of the file where
+// TLS secrets are written in NSS key log format when Session.KeyLogWriter is nil.
+const KeyLogEnv = "SSLKEYLOGFILE"
+
+var (
+	keyLogFilesMu sync.Mutex
+	keyLogFiles   = make(map[string]io.Writer)
+)
+
+// keyLogWriter returns the writer TLS secrets should be written to, or nil
+// if key logging is disabled for the session.
+func (s *Session) keyLogWriter() io.Writer {
+	if s == nil {
+		return nil
+	}
+
+	if s.KeyLogWriter != nil {
+		return s.KeyLogWriter
+	}
+
+	path := os.Getenv(KeyLogEnv)
+	if path == "" {
+		return nil
+	}
+
+	keyLogFilesMu.Lock()
+	defer keyLogFilesMu.Unlock()
+
+	if w, ok := keyLogFiles[path]; ok {
+		return w
+	}
+
+	// the file is shared by every session of the process and stays open,
+	// like browsers do with SSLKEYLOGFILE.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil
+	}
+
+	w := &lockedWriter{w: f}
+	keyLogFiles[path] = w
+
+	return w
+}
+
+// lockedWriter serializes writes coming from concurrent handshakes.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
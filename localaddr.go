@@ -0,0 +1,229 @@
+package azuretls
+
+import (
+	"container/list"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/Noooste/fhttp/httptrace"
+)
+
+// LocalAddrStrategy selects the source address of the connections in a LocalAddrPool.
+type LocalAddrStrategy int
+
+const (
+	// LocalAddrRandom uses a random address for every connection.
+	LocalAddrRandom LocalAddrStrategy = iota
+	// LocalAddrRoundRobin uses the addresses one after another.
+	LocalAddrRoundRobin
+	// LocalAddrStickyHost uses a random address per host, kept by its following
+	// connections. The addresses of the least recently used hosts are forgotten
+	// beyond maxStickyHosts hosts.
+	LocalAddrStickyHost
+)
+
+// maxStickyHosts is the number of hosts whose address is kept by LocalAddrStickyHost.
+const maxStickyHosts = 1024
+
+// LocalAddrPool is a pool of source addresses of the TCP and QUIC connections
+// of a session, see Session.LocalAddrPool. The address of a connection has the
+// IP version of its destination: if the pool has none, the system chooses it.
+type LocalAddrPool struct {
+	Strategy LocalAddrStrategy
+
+	addrs    []net.IP
+	prefixes []*net.IPNet
+
+	mu     sync.Mutex
+	next   int
+	sticky map[string]*list.Element
+	lru    *list.List // front is the most recently used
+}
+
+type stickyAddr struct {
+	key string
+	ip  net.IP
+}
+
+// NewLocalAddrPool returns a pool of the IPs and prefixes of addrs, e.g.
+// "203.0.113.7" or "2001:db8::/64". A random address of a prefix is used for
+// each connection: the system must accept binding to them, e.g. on Linux with
+// the net.ipv6.ip_nonlocal_bind sysctl and a local route to the prefix.
+func NewLocalAddrPool(strategy LocalAddrStrategy, addrs ...string) (*LocalAddrPool, error) {
+	p := &LocalAddrPool{
+		Strategy: strategy,
+	}
+
+	for _, addr := range addrs {
+		if strings.Contains(addr, "/") {
+			_, prefix, err := net.ParseCIDR(addr)
+			if err != nil {
+				return nil, err
+			}
+			p.prefixes = append(p.prefixes, prefix)
+			continue
+		}
+
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, errors.New("invalid local address " + addr)
+		}
+		p.addrs = append(p.addrs, ip)
+	}
+
+	if len(p.addrs) == 0 && len(p.prefixes) == 0 {
+		return nil, errors.New("local address pool is empty")
+	}
+
+	return p, nil
+}
+
+// pick returns the source address of a connection to host, of the IP version
+// of ipv6, or nil if the pool has none.
+func (p *LocalAddrPool) pick(host string, ipv6 bool) net.IP {
+	var (
+		addrs    []net.IP
+		prefixes []*net.IPNet
+	)
+
+	for _, ip := range p.addrs {
+		if (ip.To4() == nil) == ipv6 {
+			addrs = append(addrs, ip)
+		}
+	}
+	for _, prefix := range p.prefixes {
+		if (prefix.IP.To4() == nil) == ipv6 {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	n := len(addrs) + len(prefixes)
+	if n == 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := host
+	if ipv6 {
+		key += "/6"
+	}
+
+	if p.Strategy == LocalAddrStickyHost {
+		if elem, ok := p.sticky[key]; ok {
+			p.lru.MoveToFront(elem)
+			return elem.Value.(*stickyAddr).ip
+		}
+	}
+
+	var i int
+	if p.Strategy == LocalAddrRoundRobin {
+		i = p.next % n
+		p.next++
+	} else {
+		i = randomInt(n)
+	}
+
+	var ip net.IP
+	if i < len(addrs) {
+		ip = addrs[i]
+	} else {
+		ip = randomIPInPrefix(prefixes[i-len(addrs)])
+	}
+
+	if p.Strategy == LocalAddrStickyHost {
+		p.stick(key, ip)
+	}
+
+	return ip
+}
+
+// stick keeps ip as the address of key, forgetting the least recently used
+// hosts beyond maxStickyHosts. p.mu must be held.
+func (p *LocalAddrPool) stick(key string, ip net.IP) {
+	if p.sticky == nil {
+		p.sticky = make(map[string]*list.Element)
+		p.lru = list.New()
+	}
+
+	p.sticky[key] = p.lru.PushFront(&stickyAddr{key: key, ip: ip})
+
+	for p.lru.Len() > maxStickyHosts {
+		entry := p.lru.Remove(p.lru.Back()).(*stickyAddr)
+		delete(p.sticky, entry.key)
+	}
+}
+
+func randomInt(n int) int {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0
+	}
+	return int(i.Int64())
+}
+
+// randomIPInPrefix returns a random address of prefix.
+func randomIPInPrefix(prefix *net.IPNet) net.IP {
+	ip := make(net.IP, len(prefix.IP))
+	_, _ = rand.Read(ip)
+
+	for i := range ip {
+		ip[i] = prefix.IP[i] | (ip[i] &^ prefix.Mask[i])
+	}
+
+	return ip
+}
+
+// localAddr returns the source address of a connection to host at addr, or
+// nil without LocalAddrPool.
+func (s *Session) localAddr(host, addr string) net.IP {
+	if s.LocalAddrPool == nil {
+		return nil
+	}
+
+	ipAddr, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+
+	ip := net.ParseIP(ipAddr)
+	if ip == nil {
+		return nil
+	}
+
+	return s.LocalAddrPool.pick(host, ip.To4() == nil)
+}
+
+// localAddrTrace returns a trace recording the local address of the
+// connection carrying a request, and a function returning it. The requests
+// of a Resolver do not inherit the trace, see detachedContext; if the
+// transport retries the request on another connection, the last one is kept.
+func localAddrTrace() (*httptrace.ClientTrace, func() net.Addr) {
+	var (
+		mu   sync.Mutex
+		addr net.Addr
+	)
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Conn == nil {
+				return
+			}
+
+			mu.Lock()
+			addr = info.Conn.LocalAddr()
+			mu.Unlock()
+		},
+	}
+
+	return trace, func() net.Addr {
+		mu.Lock()
+		defer mu.Unlock()
+		return addr
+	}
+}
@@ -1,19 +1,93 @@
 package azuretls
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	http "github.com/Noooste/fhttp"
-	"github.com/fatih/color"
+	"io"
+	"log/slog"
 	"net/url"
+	"os"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
+
+	http "github.com/Noooste/fhttp"
+	"github.com/fatih/color"
 )
 
+// Messages of the records emitted by the session logger.
+const (
+	LogMessageRequest  = "request"
+	LogMessageResponse = "response"
+	LogMessageError    = "request failed"
+)
+
+// Error classes reported in the "error_class" attribute of failed requests.
+const (
+	ErrorClassTimeout  = "timeout"
+	ErrorClassCanceled = "canceled"
+	ErrorClassProxy    = "proxy"
+	ErrorClassDial     = "dial"
+	ErrorClassPin      = "pin"
+	ErrorClassTLS      = "tls"
+	ErrorClassBody     = "body"
+	ErrorClassOther    = "other"
+)
+
+const redacted = "[REDACTED]"
+
+var defaultSensitiveQuery = []string{
+	"token", "access_token", "refresh_token", "id_token", "api_key", "apikey", "key",
+	"password", "secret", "client_secret", "signature", "sig", "auth", "session", "code",
+}
+
+// LogConfig defines the levels and the redaction rules used by the session logger.
+type LogConfig struct {
+	// Level of the record emitted before a request is sent.
+	RequestLevel slog.Level
+	// Level of the record emitted when a response is received.
+	ResponseLevel slog.Level
+	// Level of the record emitted when a request fails.
+	ErrorLevel slog.Level
+
+	// If true, request and response headers are added to the records.
+	Headers bool
+	// Header names (case-insensitive) whose values are replaced by "[REDACTED]".
+	RedactHeaders []string
+	// Query parameter names (case-insensitive) whose values are replaced by "[REDACTED]"
+	// in logged URLs. The password of the URL user info is always redacted.
+	RedactQuery []string
+
+	// If true, request and response bodies are added to the records.
+	Body bool
+	// Maximum number of body bytes to log, 0 means no limit.
+	MaxBodySize int
+	// Parts of the bodies matching these expressions are replaced by "[REDACTED]".
+	RedactBody []*regexp.Regexp
+}
+
+// DefaultLogConfig returns the configuration used when none is given to SetLogger:
+// requests are logged at debug level, responses at info level and failures at error level,
+// without headers nor bodies.
+func DefaultLogConfig() LogConfig {
+	return LogConfig{
+		RequestLevel:  slog.LevelDebug,
+		ResponseLevel: slog.LevelInfo,
+		ErrorLevel:    slog.LevelError,
+		RedactHeaders: append([]string{"Proxy-Authorization"}, defaultSensitiveHeaders...),
+		RedactQuery:   append([]string(nil), defaultSensitiveQuery...),
+		MaxBodySize:   4096,
+	}
+}
+
 // Log will print the request and response to the console
 //
 // uris (optional) is a list of uris to ignore,
 // if ignore is empty, all uris will be logged
+//
+// If no logger was set with SetLogger, the colored console output is used.
 func (s *Session) Log(uris ...string) {
 	s.logging = true
 
@@ -30,6 +104,27 @@ func (s *Session) Log(uris ...string) {
 	}
 }
 
+// SetLogger enables logging of requests and responses as structured records
+// to the given logger. The first config, if any, replaces DefaultLogConfig.
+// Uris ignored with Log are not logged.
+func (s *Session) SetLogger(logger *slog.Logger, config ...LogConfig) {
+	s.logger = logger
+
+	if len(config) > 0 {
+		s.logConfig = &config[0]
+	} else {
+		c := DefaultLogConfig()
+		s.logConfig = &c
+	}
+
+	s.logging = logger != nil
+}
+
+// Logger returns the logger used by the session, nil if SetLogger was not called.
+func (s *Session) Logger() *slog.Logger {
+	return s.logger
+}
+
 // DisableLog will disable request and response logging
 func (s *Session) DisableLog() {
 	s.logging = false
@@ -51,14 +146,16 @@ func (s *Session) LogIgnore(uri string) bool {
 	return s.urlMatch(parsed, s.loggingIgnore)
 }
 
-var colorMethodMap = map[string]*color.Color{
-	http.MethodGet:     color.New(color.BgBlue, color.FgHiWhite),
-	http.MethodPost:    color.New(color.BgHiBlue, color.FgHiWhite),
-	http.MethodPut:     color.New(color.BgHiYellow, color.FgBlack),
-	http.MethodPatch:   color.New(color.BgHiMagenta, color.FgHiWhite),
-	http.MethodDelete:  color.New(color.BgHiRed, color.FgHiWhite),
-	http.MethodOptions: color.New(color.BgHiCyan, color.FgHiWhite),
-	http.MethodConnect: color.New(color.BgHiWhite, color.FgBlack),
+var defaultConsoleLogger = slog.New(NewColorHandler(os.Stdout))
+
+// sessionLogger returns the configured logger and its config, defaulting to the colored console output.
+func (s *Session) sessionLogger() (*slog.Logger, *LogConfig) {
+	if s.logger != nil && s.logConfig != nil {
+		return s.logger, s.logConfig
+	}
+
+	c := DefaultLogConfig()
+	return defaultConsoleLogger, &c
 }
 
 func (s *Session) logRequest(request *Request) {
@@ -66,13 +163,207 @@ func (s *Session) logRequest(request *Request) {
 		return
 	}
 
-	fmt.Printf("[%s] %v |%s | %25s | %#v\n",
-		color.CyanString("AZURETLS"),
-		request.startTime.Format("01/02/2006 - 15:04:05"),
-		colorMethodMap[request.Method].Sprintf(" %-8s", request.Method),
-		request.parsedUrl.Host,
-		request.parsedUrl.Path,
+	logger, config := s.sessionLogger()
+	ctx := request.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if !logger.Enabled(ctx, config.RequestLevel) {
+		return
+	}
+
+	attrs := s.requestAttrs(request, config)
+
+	if config.Headers {
+		attrs = append(attrs, headersAttr("headers", request.HttpRequest.Header, config))
+	}
+
+	if config.Body && request.body != nil {
+		attrs = append(attrs, slog.String("body", redactBody(request.body, config)))
+	}
+
+	logger.LogAttrs(ctx, config.RequestLevel, LogMessageRequest, attrs...)
+}
+
+func (s *Session) logResponse(response *Response, err error) {
+	if !s.logging || s.urlMatch(response.Request.parsedUrl, s.loggingIgnore) {
+		return
+	}
+
+	logger, config := s.sessionLogger()
+	ctx := response.Request.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	level := config.ResponseLevel
+	if err != nil {
+		level = config.ErrorLevel
+	}
+
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+
+	attrs := s.requestAttrs(response.Request, config)
+	attrs = append(attrs, slog.Duration("duration", time.Since(response.Request.startTime)))
+
+	if err != nil {
+		attrs = append(attrs,
+			slog.String("error", err.Error()),
+			slog.String("error_class", ErrorClass(err)),
+		)
+		logger.LogAttrs(ctx, level, LogMessageError, attrs...)
+		return
+	}
+
+	attrs = append(attrs,
+		slog.Int("status", response.StatusCode),
+		slog.String("protocol", response.HttpResponse.Proto),
 	)
+
+	if config.Headers {
+		attrs = append(attrs, headersAttr("response_headers", response.Header, config))
+	}
+
+	if config.Body && response.Body != nil {
+		attrs = append(attrs, slog.String("response_body", redactBody(response.Body, config)))
+	}
+
+	logger.LogAttrs(ctx, level, LogMessageResponse, attrs...)
+}
+
+func (s *Session) requestAttrs(request *Request, config *LogConfig) []slog.Attr {
+	attrs := make([]slog.Attr, 0, 12)
+	attrs = append(attrs,
+		slog.String("method", request.Method),
+		slog.String("url", redactURL(request.parsedUrl, config)),
+		slog.String("host", request.parsedUrl.Host),
+		slog.String("path", request.parsedUrl.Path),
+		slog.Int("redirect_index", request.redirectIndex),
+	)
+
+	if s.ProxyDialer != nil {
+		attrs = append(attrs, slog.String("proxy", s.ProxyDialer.proxyLabel()))
+	}
+
+	return attrs
+}
+
+func headersAttr(key string, header http.Header, config *LogConfig) slog.Attr {
+	attrs := make([]any, 0, len(header))
+
+	for k, v := range header {
+		if k == http.HeaderOrderKey || k == http.PHeaderOrderKey {
+			continue
+		}
+
+		value := strings.Join(v, ", ")
+		for _, r := range config.RedactHeaders {
+			if strings.EqualFold(k, r) {
+				value = redacted
+				break
+			}
+		}
+
+		attrs = append(attrs, slog.String(strings.ToLower(k), value))
+	}
+
+	return slog.Group(key, attrs...)
+}
+
+// redactURL returns u without the password of its user info
+// and with the values of the query parameters listed in config.RedactQuery replaced.
+func redactURL(u *url.URL, config *LogConfig) string {
+	if u == nil {
+		return ""
+	}
+
+	if u.RawQuery == "" || len(config.RedactQuery) == 0 {
+		return u.Redacted()
+	}
+
+	params := strings.Split(u.RawQuery, "&")
+	for i, param := range params {
+		rawKey, _, hasValue := strings.Cut(param, "=")
+		if !hasValue {
+			continue
+		}
+
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil {
+			key = rawKey
+		}
+
+		for _, r := range config.RedactQuery {
+			if strings.EqualFold(key, r) {
+				params[i] = rawKey + "=" + redacted
+				break
+			}
+		}
+	}
+
+	c := *u
+	c.RawQuery = strings.Join(params, "&")
+	return c.Redacted()
+}
+
+func redactBody(body []byte, config *LogConfig) string {
+	if config.MaxBodySize > 0 && len(body) > config.MaxBodySize {
+		body = body[:config.MaxBodySize]
+	}
+
+	for _, r := range config.RedactBody {
+		body = r.ReplaceAll(body, []byte(redacted))
+	}
+
+	return string(body)
+}
+
+// ErrorClass returns a coarse classification of an error returned by the session,
+// such as ErrorClassTimeout or ErrorClassProxy.
+func ErrorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return ErrorClassCanceled
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorClassTimeout
+	}
+
+	msg := err.Error()
+
+	switch {
+	case strings.Contains(msg, "timeout"):
+		return ErrorClassTimeout
+	case strings.Contains(msg, "proxy"):
+		return ErrorClassProxy
+	case strings.Contains(msg, "pin verification"), strings.Contains(msg, "failed to pin"):
+		return ErrorClassPin
+	case strings.Contains(msg, "failed to dial"), strings.Contains(msg, "dial tcp"), strings.Contains(msg, "dial udp"):
+		return ErrorClassDial
+	case strings.Contains(msg, "handshake"), strings.Contains(msg, "tls:"), strings.Contains(msg, "certificate"):
+		return ErrorClassTLS
+	case strings.Contains(msg, "read body"):
+		return ErrorClassBody
+	default:
+		return ErrorClassOther
+	}
+}
+
+var colorMethodMap = map[string]*color.Color{
+	http.MethodGet:     color.New(color.BgBlue, color.FgHiWhite),
+	http.MethodPost:    color.New(color.BgHiBlue, color.FgHiWhite),
+	http.MethodPut:     color.New(color.BgHiYellow, color.FgBlack),
+	http.MethodPatch:   color.New(color.BgHiMagenta, color.FgHiWhite),
+	http.MethodDelete:  color.New(color.BgHiRed, color.FgHiWhite),
+	http.MethodOptions: color.New(color.BgHiCyan, color.FgHiWhite),
+	http.MethodConnect: color.New(color.BgHiWhite, color.FgBlack),
 }
 
 func getColorStatus(status int) *color.Color {
@@ -88,34 +379,112 @@ func getColorStatus(status int) *color.Color {
 	}
 }
 
-func (s *Session) logResponse(response *Response, err error) {
-	if !s.logging || s.urlMatch(response.Request.parsedUrl, s.loggingIgnore) {
-		return
-	}
+// ColorHandler is a slog.Handler printing session records as colored
+// console lines. It is the handler used by Log when no logger is set.
+type ColorHandler struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
 
-	now := time.Now()
+// NewColorHandler returns a ColorHandler writing to w.
+func NewColorHandler(w io.Writer) *ColorHandler {
+	return &ColorHandler{mu: new(sync.Mutex), w: w}
+}
 
-	if err != nil {
-		fmt.Printf("[%s] %v | %s | %13v | %25s | %#v\n",
+// Enabled implements slog.Handler, every level is printed.
+func (h *ColorHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+// WithAttrs implements slog.Handler, the console format does not print extra attributes.
+func (h *ColorHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+// WithGroup implements slog.Handler, the console format does not print groups.
+func (h *ColorHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// Handle implements slog.Handler.
+func (h *ColorHandler) Handle(_ context.Context, r slog.Record) error {
+	var (
+		method, host, path, proto, errMsg string
+		status                            int64
+		duration                          time.Duration
+	)
+
+	r.Attrs(func(a slog.Attr) bool {
+		switch a.Key {
+		case "method":
+			method = a.Value.String()
+		case "host":
+			host = a.Value.String()
+		case "path":
+			path = a.Value.String()
+		case "protocol":
+			proto = a.Value.String()
+		case "error":
+			errMsg = a.Value.String()
+		case "status":
+			status = a.Value.Int64()
+		case "duration":
+			duration = a.Value.Duration()
+		}
+		return true
+	})
+
+	var line string
+
+	switch r.Message {
+	case LogMessageRequest:
+		methodColor, ok := colorMethodMap[method]
+		if !ok {
+			methodColor = color.New(color.BgWhite, color.FgBlack)
+		}
+
+		line = fmt.Sprintf("[%s] %v |%s | %25s | %#v\n",
 			color.CyanString("AZURETLS"),
-			now.Format("01/02/2006 - 15:04:05"),
-			color.New(color.BgRed, color.FgBlack).Sprint(err),
-			now.Sub(response.Request.startTime),
-			response.Request.parsedUrl.Host,
-			response.Request.parsedUrl.Path,
+			r.Time.Format("01/02/2006 - 15:04:05"),
+			methodColor.Sprintf(" %-8s", method),
+			host,
+			path,
+		)
+
+	case LogMessageError:
+		line = fmt.Sprintf("[%s] %v | %s | %13v | %25s | %#v\n",
+			color.CyanString("AZURETLS"),
+			r.Time.Format("01/02/2006 - 15:04:05"),
+			color.New(color.BgRed, color.FgBlack).Sprint(errMsg),
+			duration,
+			host,
+			path,
+		)
+
+	case LogMessageResponse:
+		line = fmt.Sprintf("[%s] %v |%s| %13v | %8s | %25s | %#v\n",
+			color.CyanString("AZURETLS"),
+			r.Time.Format("01/02/2006 - 15:04:05"),
+			getColorStatus(int(status)).Sprintf(" %3d ", status),
+			duration,
+			proto,
+			host,
+			path,
+		)
+
+	default:
+		line = fmt.Sprintf("[%s] %v | %s\n",
+			color.CyanString("AZURETLS"),
+			r.Time.Format("01/02/2006 - 15:04:05"),
+			r.Message,
 		)
-		return
 	}
 
-	fmt.Printf("[%s] %v |%s| %13v | %8s | %25s | %#v\n",
-		color.CyanString("AZURETLS"),
-		now.Format("01/02/2006 - 15:04:05"),
-		getColorStatus(response.StatusCode).Sprintf(" %3d ", response.StatusCode),
-		now.Sub(response.Request.startTime),
-		response.HttpResponse.Proto,
-		response.Request.parsedUrl.Host,
-		response.Request.parsedUrl.Path,
-	)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, err := io.WriteString(h.w, line)
+	return err
 }
 
 // EnableVerbose enables verbose logging
@@ -0,0 +1,231 @@
+// Package metrics exposes azuretls session activity as Prometheus metrics.
+//
+// A single Collector can instrument any number of sessions; each one is
+// identified by the name given to Instrument:
+//
+//	collector := metrics.NewCollector()
+//	prometheus.MustRegister(collector)
+//
+//	session := azuretls.NewSession()
+//	collector.Instrument("checkout", session)
+//
+// Traffic of TCP connections is counted on the wire. QUIC connections cannot
+// be wrapped, so the traffic of HTTP/3 requests is an estimate: the size of
+// the request and response bodies, without headers, framing and handshakes.
+package metrics
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Noooste/azuretls-client"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "azuretls"
+
+// Collector is a prometheus.Collector reporting per-session and per-host
+// request counts, latencies, traffic, open connections and failures.
+type Collector struct {
+	requests        *prometheus.CounterVec
+	duration        *prometheus.HistogramVec
+	bytesIn         *prometheus.CounterVec
+	bytesOut        *prometheus.CounterVec
+	openConnections *prometheus.GaugeVec
+	proxyFailures   *prometheus.CounterVec
+	pinFailures     *prometheus.CounterVec
+	retries         *prometheus.CounterVec
+	redirects       *prometheus.CounterVec
+}
+
+// NewCollector creates a Collector. Buckets are used for the request
+// latency histogram; prometheus.DefBuckets is used when none are given.
+func NewCollector(buckets ...float64) *Collector {
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
+
+	return &Collector{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_total",
+			Help:      "Number of requests sent, by session, host and status code (\"error\" when no response was received).",
+		}, []string{"session", "host", "code"}),
+
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "request_duration_seconds",
+			Help:      "Time from sending a request to receiving its full response.",
+			Buckets:   buckets,
+		}, []string{"session", "host"}),
+
+		bytesIn: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "received_bytes_total",
+			Help:      "Bytes received, on the wire for TCP connections and estimated from the body size for HTTP/3.",
+		}, []string{"session", "host", "proto"}),
+
+		bytesOut: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "sent_bytes_total",
+			Help:      "Bytes sent, on the wire for TCP connections and estimated from the body size for HTTP/3.",
+		}, []string{"session", "host", "proto"}),
+
+		openConnections: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "open_connections",
+			Help:      "Number of open connections by protocol (h1, h2, h3).",
+		}, []string{"session", "proto"}),
+
+		proxyFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "proxy_failures_total",
+			Help:      "Number of failed dials through a proxy.",
+		}, []string{"session", "proxy"}),
+
+		pinFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "pin_failures_total",
+			Help:      "Number of certificate pin verification failures.",
+		}, []string{"session", "host"}),
+
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "retries_total",
+			Help:      "Number of requests sent again, by reason (critical-ch).",
+		}, []string{"session", "reason"}),
+
+		redirects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "redirects_total",
+			Help:      "Number of redirects followed, by host of the new location.",
+		}, []string{"session", "host"}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.requests.Describe(ch)
+	c.duration.Describe(ch)
+	c.bytesIn.Describe(ch)
+	c.bytesOut.Describe(ch)
+	c.openConnections.Describe(ch)
+	c.proxyFailures.Describe(ch)
+	c.pinFailures.Describe(ch)
+	c.retries.Describe(ch)
+	c.redirects.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.requests.Collect(ch)
+	c.duration.Collect(ch)
+	c.bytesIn.Collect(ch)
+	c.bytesOut.Collect(ch)
+	c.openConnections.Collect(ch)
+	c.proxyFailures.Collect(ch)
+	c.pinFailures.Collect(ch)
+	c.retries.Collect(ch)
+	c.redirects.Collect(ch)
+}
+
+// Instrument registers the collector on the session under the given name.
+// It adds a callback to the session and replaces its Observer, so it should
+// be called before the session sends its first request.
+func (c *Collector) Instrument(name string, s *azuretls.Session) {
+	s.Observer = &sessionObserver{c: c, session: name}
+	s.UseCallbackWithContext(func(ctx *azuretls.Context) {
+		c.observeRequest(name, ctx)
+	})
+}
+
+func (c *Collector) observeRequest(session string, ctx *azuretls.Context) {
+	if ctx.Request == nil || ctx.Request.HttpRequest == nil {
+		return
+	}
+
+	host := ctx.Request.HttpRequest.URL.Host
+
+	code := "error"
+	if ctx.Err == nil && ctx.Response != nil {
+		code = strconv.Itoa(ctx.Response.StatusCode)
+	}
+
+	c.requests.WithLabelValues(session, host, code).Inc()
+
+	if !ctx.RequestStartTime.IsZero() {
+		c.duration.WithLabelValues(session, host).Observe(time.Since(ctx.RequestStartTime).Seconds())
+	}
+
+	// TCP traffic is counted on the wire by the observer, HTTP/3 is
+	// approximated with body sizes as QUIC connections cannot be wrapped.
+	if ctx.Response != nil && ctx.Response.HttpResponse != nil && ctx.Response.HttpResponse.ProtoMajor == 3 {
+		if ctx.Request.ContentLength > 0 {
+			c.bytesOut.WithLabelValues(session, host, azuretls.ProtoHTTP3).Add(float64(ctx.Request.ContentLength))
+		}
+		c.bytesIn.WithLabelValues(session, host, azuretls.ProtoHTTP3).Add(float64(len(ctx.Response.Body)))
+	}
+}
+
+// sessionObserver forwards the events of one session to the collector.
+type sessionObserver struct {
+	c       *Collector
+	session string
+}
+
+func (o *sessionObserver) ConnOpened(_, proto string) {
+	o.c.openConnections.WithLabelValues(o.session, proto).Inc()
+}
+
+func (o *sessionObserver) ConnClosed(_, proto string) {
+	o.c.openConnections.WithLabelValues(o.session, proto).Dec()
+}
+
+func (o *sessionObserver) BytesRead(addr, proto string, n int) {
+	o.c.bytesIn.WithLabelValues(o.session, hostLabel(addr), proto).Add(float64(n))
+}
+
+func (o *sessionObserver) BytesWritten(addr, proto string, n int) {
+	o.c.bytesOut.WithLabelValues(o.session, hostLabel(addr), proto).Add(float64(n))
+}
+
+func (o *sessionObserver) ProxyFailed(proxy string, _ error) {
+	o.c.proxyFailures.WithLabelValues(o.session, proxy).Inc()
+}
+
+func (o *sessionObserver) PinFailed(addr string) {
+	o.c.pinFailures.WithLabelValues(o.session, hostLabel(addr)).Inc()
+}
+
+func (o *sessionObserver) Retried(_ *azuretls.Request, reason string) {
+	o.c.retries.WithLabelValues(o.session, reason).Inc()
+}
+
+func (o *sessionObserver) Redirected(request *azuretls.Request) {
+	var host string
+	if u, err := url.Parse(request.Url); err == nil {
+		host = u.Host
+	}
+	o.c.redirects.WithLabelValues(o.session, host).Inc()
+}
+
+// hostLabel strips the default HTTPS/HTTP port so that wire metrics use the
+// same host label as request metrics.
+func hostLabel(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	if port == "443" || port == "80" {
+		if strings.Contains(host, ":") {
+			return "[" + host + "]"
+		}
+		return host
+	}
+
+	return addr
+}
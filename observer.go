@@ -0,0 +1,127 @@
+package azuretls
+
+import (
+	"net"
+	"strings"
+	"sync"
+)
+
+// Protocol labels reported to an Observer.
+const (
+	ProtoHTTP1 = "h1"
+	ProtoHTTP2 = "h2"
+	ProtoHTTP3 = "h3"
+)
+
+// Observer receives low-level session events that are not visible from
+// request callbacks, such as connection lifecycle, wire traffic and
+// verification failures. Methods may be called concurrently from any
+// goroutine and must not block.
+//
+// Embed NopObserver to implement only the events you care about.
+type Observer interface {
+	// ConnOpened is called once a connection is ready to carry requests.
+	ConnOpened(addr, proto string)
+	// ConnClosed is called when a connection previously reported by ConnOpened is closed.
+	ConnClosed(addr, proto string)
+	// BytesRead reports bytes read from the wire (TCP connections only, QUIC
+	// connections cannot be wrapped).
+	BytesRead(addr, proto string, n int)
+	// BytesWritten reports bytes written to the wire (TCP connections only).
+	BytesWritten(addr, proto string, n int)
+	// ProxyFailed is called when dialing through the proxy failed.
+	ProxyFailed(proxy string, err error)
+	// PinFailed is called when the certificate presented by addr does not match its pins.
+	PinFailed(addr string)
+	// Retried is called when the same request is sent again, e.g. with the
+	// client hints a Critical-CH response header asked for.
+	Retried(request *Request, reason string)
+	// Redirected is called with the request following a redirect response.
+	Redirected(request *Request)
+}
+
+// NopObserver is an Observer that ignores every event.
+type NopObserver struct{}
+
+func (NopObserver) ConnOpened(string, string)        {}
+func (NopObserver) ConnClosed(string, string)        {}
+func (NopObserver) BytesRead(string, string, int)    {}
+func (NopObserver) BytesWritten(string, string, int) {}
+func (NopObserver) ProxyFailed(string, error)        {}
+func (NopObserver) PinFailed(string)                 {}
+func (NopObserver) Retried(*Request, string)         {}
+func (NopObserver) Redirected(*Request)              {}
+
+// observedConn reports traffic and lifecycle of a TCP connection to an Observer.
+type observedConn struct {
+	net.Conn
+
+	observer Observer
+	addr     string
+	proto    string
+
+	opened    bool
+	closeOnce sync.Once
+}
+
+func (s *Session) observeConn(conn net.Conn, addr string) net.Conn {
+	if s.Observer == nil {
+		return conn
+	}
+
+	return &observedConn{
+		Conn:     conn,
+		observer: s.Observer,
+		addr:     addr,
+		proto:    ProtoHTTP1,
+	}
+}
+
+// markOpened sets the negotiated protocol and reports the connection as opened.
+func markOpened(conn net.Conn, negotiatedProtocol string) {
+	c, ok := conn.(*observedConn)
+	if !ok {
+		return
+	}
+
+	if negotiatedProtocol == "h2" {
+		c.proto = ProtoHTTP2
+	}
+
+	c.opened = true
+	c.observer.ConnOpened(c.addr, c.proto)
+}
+
+func (c *observedConn) Read(p []byte) (n int, err error) {
+	n, err = c.Conn.Read(p)
+	if n > 0 {
+		c.observer.BytesRead(c.addr, c.proto, n)
+	}
+	return
+}
+
+func (c *observedConn) Write(p []byte) (n int, err error) {
+	n, err = c.Conn.Write(p)
+	if n > 0 {
+		c.observer.BytesWritten(c.addr, c.proto, n)
+	}
+	return
+}
+
+func (c *observedConn) Close() error {
+	c.closeOnce.Do(func() {
+		if c.opened {
+			c.observer.ConnClosed(c.addr, c.proto)
+		}
+	})
+	return c.Conn.Close()
+}
+
+// proxyLabel returns the proxy hosts without credentials, suitable for metrics and logs.
+func (c *proxyDialer) proxyLabel() string {
+	hosts := make([]string, 0, len(c.ProxyChain))
+	for _, p := range c.ProxyChain {
+		hosts = append(hosts, p.Scheme+"://"+p.Host)
+	}
+	return strings.Join(hosts, ",")
+}
@@ -1,6 +1,7 @@
 package azuretls
 
 import (
+	"context"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
@@ -28,8 +29,13 @@ func Fingerprint(c *x509.Certificate) string {
 }
 
 type PinHost struct {
-	mu sync.RWMutex    // Read-Write mutex ensuring concurrent access safety.
-	m  map[string]bool // A map representing the certificate pins. If a pin exists and is set to true, it is considered valid.
+	mu     sync.RWMutex    // Read-Write mutex ensuring concurrent access safety.
+	m      map[string]bool // A map representing the certificate pins. If a pin exists and is set to true, it is considered valid.
+	backup map[string]bool // Backup pins, accepted like the pins but not generated from certificates.
+
+	includeSubdomains bool          // If true, the pins also apply to the subdomains of the host without pins.
+	maxAge            time.Duration // Lifetime of the pins from pinnedAt, 0 meaning they never expire.
+	pinnedAt          time.Time     // Time the pins were set.
 }
 
 // PinManager is a concurrency-safe struct designed to manage
@@ -37,9 +43,29 @@ type PinHost struct {
 // is a security feature which can be used to specify a set of valid public keys
 // for a particular web service, thus preventing man-in-the-middle attacks
 // due to rogue certificates.
+//
+// Pins are generated on the first connection to a host (trust on first use),
+// or loaded from a PinPolicy, see SetPolicy and Load.
 type PinManager struct {
 	hosts map[string]*PinHost // A map of PinHost instances, each representing a set of pins for a specific host.
 	mu    sync.RWMutex        // Read-Write mutex ensuring concurrent access safety.
+
+	// MaxAge is the lifetime of the pins generated on the first connection to a
+	// host, 0 meaning they never expire. Once the pins of a host expired, the next
+	// connection to it pins its certificates again.
+	MaxAge time.Duration
+
+	// ReportOnly makes pin verification failures call OnPinFailure instead of
+	// failing the handshake, e.g. to roll out a new policy.
+	ReportOnly bool
+
+	// OnPinFailure is called with the certificate chain of host when it matches
+	// none of its pins.
+	OnPinFailure func(host string, chain []*x509.Certificate)
+
+	// OnPin is called when the certificates of a connection are pinned, e.g. to
+	// persist the new policy. It must not block the handshake.
+	OnPin func(policy PinPolicy)
 }
 
 // NewPinManager initializes a new instance of PinManager with
@@ -78,9 +104,11 @@ func (p *PinHost) Verify(c *x509.Certificate) bool {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	v, ok := p.m[fp]
+	if v, ok := p.m[fp]; ok && v {
+		return true
+	}
 
-	return ok && v
+	return p.backup[fp]
 }
 
 // New establishes a connection to the provided address, retrieves
@@ -88,9 +116,14 @@ func (p *PinHost) Verify(c *x509.Certificate) bool {
 // PinManager. This can be used initially to populate the PinManager
 // with pins from a trusted service.
 //
-// If a Session is provided, it will use the same TLS configuration
-// (including ClientHello spec) as the actual connection to ensure
-// the same certificate chain is obtained.
+// If a Session is provided, it will connect through the same proxy and
+// dialer and use the same TLS configuration (including ClientHello spec)
+// as the actual connection to ensure the same certificate chain is
+// obtained, and the chain is only pinned if it is trusted by the roots
+// of the Session (see Session.RootCAs).
+//
+// Requests do not need it: the first connection to a host pins the
+// certificates of its own handshake.
 func (p *PinHost) New(addr string, s *Session) (err error) {
 	var cs tls.ConnectionState
 
@@ -102,13 +135,16 @@ func (p *PinHost) New(addr string, s *Session) (err error) {
 			return errors.New("failed to split addr and port: " + err.Error())
 		}
 
-		// Create a raw TCP connection first (not TLS)
-		dialer := &net.Dialer{
-			Timeout:   s.TimeOut,
-			KeepAlive: 30 * time.Second,
+		// Create a raw TCP connection first (not TLS), through the proxy
+		// and dialer of the session like the requests
+		ctx := context.Background()
+		if s.TimeOut > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, s.TimeOut)
+			defer cancel()
 		}
 
-		conn, err := dialer.Dial("tcp", addr)
+		conn, err := s.dial(ctx, "tcp", addr)
 		if err != nil {
 			return errors.New("failed to dial for pin generation: " + err.Error())
 		}
@@ -117,6 +153,7 @@ func (p *PinHost) New(addr string, s *Session) (err error) {
 		config := &tls.Config{
 			ServerName:         hostname,
 			InsecureSkipVerify: true,
+			OmitEmptyPsk:       true,
 		}
 
 		if s.ModifyConfig != nil {
@@ -129,12 +166,7 @@ func (p *PinHost) New(addr string, s *Session) (err error) {
 		// Use UClient with the same ClientHello spec as actual connections
 		tlsConn := tls.UClient(conn, config, tls.HelloCustom)
 
-		var fn = s.GetClientHelloSpec
-		if fn == nil {
-			fn = GetBrowserClientHelloFunc(s.Browser)
-		}
-
-		specs := fn()
+		specs := s.clientHelloSpec()
 
 		if err = tlsConn.ApplyPreset(specs); err != nil {
 			conn.Close()
@@ -148,6 +180,11 @@ func (p *PinHost) New(addr string, s *Session) (err error) {
 
 		cs = tlsConn.ConnectionState()
 		_ = tlsConn.Close()
+
+		// only pin a chain trusted by the session roots (RootCAs, AdditionalRootCAs)
+		if _, err = s.verifyChains(cs.PeerCertificates, hostname); err != nil {
+			return errors.New("failed to verify certificate for pin generation: " + err.Error())
+		}
 	} else {
 		// Fallback to simple dial if no Session is provided
 		dial, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
@@ -199,9 +236,11 @@ func (p *PinManager) Clear(host string) {
 func (p *PinManager) AddHost(host string, s *Session) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if _, ok := p.hosts[host]; !ok {
-		ph := &PinHost{
-			m: make(map[string]bool),
+	if ph, ok := p.hosts[host]; !ok || ph.expired(time.Now()) {
+		ph = &PinHost{
+			m:        make(map[string]bool),
+			maxAge:   p.MaxAge,
+			pinnedAt: time.Now(),
 		}
 		if err := ph.New(host, s); err != nil {
 			return err
@@ -227,6 +266,97 @@ func (p *PinManager) AddPins(host string, pins []string) {
 	}
 }
 
+// applyPins makes config verify the pins of addr before the other checks of
+// VerifyConnection, which unlike VerifyPeerCertificate also runs on resumed
+// handshakes. hostname is the name the certificates are verified for.
+func (s *Session) applyPins(config *tls.Config, addr, hostname string) {
+	if config.InsecureSkipVerify {
+		return
+	}
+
+	verify := config.VerifyConnection
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		if err := s.verifyPins(addr, hostname, cs); err != nil {
+			return err
+		}
+
+		if verify != nil {
+			return verify(cs)
+		}
+
+		return nil
+	}
+}
+
+// verifyPins checks the chains of cs against the pins of addr. The first
+// connection to addr pins the certificates of its own handshake, made through
+// the proxy and dialer of the request.
+func (s *Session) verifyPins(addr, hostname string, cs tls.ConnectionState) error {
+	if s.PinManager.pinCertificates(addr, cs.PeerCertificates) {
+		return nil
+	}
+
+	pins := s.PinManager.hostPins(addr)
+	if pins == nil {
+		return errors.New("no pins found for " + addr)
+	}
+
+	// the chains are verified by VerifyPeerCertificate when the session
+	// verifies them against its own roots, see applyRootCAs
+	chains := cs.VerifiedChains
+	if len(chains) == 0 {
+		var err error
+		if chains, err = s.verifyChains(cs.PeerCertificates, hostname); err != nil {
+			return &tls.CertificateVerificationError{UnverifiedCertificates: cs.PeerCertificates, Err: err}
+		}
+	}
+
+	for _, chain := range chains {
+		for _, cert := range chain {
+			if pins.Verify(cert) {
+				return nil
+			}
+		}
+	}
+
+	if s.Observer != nil {
+		s.Observer.PinFailed(addr)
+	}
+
+	return s.PinManager.pinFailure(addr, chains)
+}
+
+// pinCertificates pins the certificates sent by host if it has no pins yet
+// or its pins expired, and reports whether it did.
+func (p *PinManager) pinCertificates(host string, certs []*x509.Certificate) bool {
+	now := time.Now()
+
+	p.mu.Lock()
+	if p.lookup(host, now) != nil {
+		p.mu.Unlock()
+		return false
+	}
+
+	ph := &PinHost{
+		m:        make(map[string]bool, len(certs)),
+		maxAge:   p.MaxAge,
+		pinnedAt: now,
+	}
+
+	for _, cert := range certs {
+		ph.m[Fingerprint(cert)] = true
+	}
+
+	p.hosts[host] = ph
+	p.mu.Unlock()
+
+	if p.OnPin != nil {
+		p.OnPin(ph.policy(host))
+	}
+
+	return true
+}
+
 // GetHost retrieves the PinHost associated with a specific host.
 // This is useful for checking if a host has any pinned certificates
 // and for verifying certificates against the pins.
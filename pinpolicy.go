@@ -0,0 +1,224 @@
+package azuretls
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PinPolicy is the pinning policy of a host, the format used by
+// PinManager.Save and PinManager.Load.
+type PinPolicy struct {
+	// Host is the address of the host, e.g. example.com:443. The port defaults to 443.
+	Host string `json:"host"`
+	// Pins are the fingerprints of the certificates of the host, see Fingerprint.
+	Pins []string `json:"pins"`
+	// BackupPins are accepted like Pins, e.g. the key of the next certificate of the host.
+	BackupPins []string `json:"backup_pins,omitempty"`
+	// IncludeSubdomains applies the policy to the subdomains of Host without a policy.
+	IncludeSubdomains bool `json:"include_subdomains,omitempty"`
+	// MaxAge is the lifetime of the policy in seconds from PinnedAt, 0 meaning it
+	// never expires. Once expired, the next connection pins the certificates again.
+	MaxAge int64 `json:"max_age,omitempty"`
+	// PinnedAt is the time the pins were set. If zero, SetPolicy uses the current time.
+	PinnedAt time.Time `json:"pinned_at"`
+}
+
+// normalizePinHost returns host as the addresses used by the pins of connections.
+func normalizePinHost(host string) string {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "443")
+	}
+	return host
+}
+
+func (p *PinHost) expired(now time.Time) bool {
+	return p.maxAge > 0 && now.After(p.pinnedAt.Add(p.maxAge))
+}
+
+func (p *PinHost) policy(host string) PinPolicy {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	policy := PinPolicy{
+		Host:              host,
+		IncludeSubdomains: p.includeSubdomains,
+		MaxAge:            int64(p.maxAge / time.Second),
+		PinnedAt:          p.pinnedAt,
+	}
+
+	for pin, ok := range p.m {
+		if ok {
+			policy.Pins = append(policy.Pins, pin)
+		}
+	}
+	for pin := range p.backup {
+		policy.BackupPins = append(policy.BackupPins, pin)
+	}
+
+	sort.Strings(policy.Pins)
+	sort.Strings(policy.BackupPins)
+
+	return policy
+}
+
+// SetPolicy replaces the pins of the host of policy.
+func (p *PinManager) SetPolicy(policy PinPolicy) error {
+	if policy.Host == "" {
+		return errors.New("pin policy has no host")
+	}
+	if len(policy.Pins) == 0 && len(policy.BackupPins) == 0 {
+		return errors.New("pin policy of " + policy.Host + " has no pins")
+	}
+
+	ph := &PinHost{
+		m:                 make(map[string]bool, len(policy.Pins)),
+		backup:            make(map[string]bool, len(policy.BackupPins)),
+		includeSubdomains: policy.IncludeSubdomains,
+		maxAge:            time.Duration(policy.MaxAge) * time.Second,
+		pinnedAt:          policy.PinnedAt,
+	}
+
+	if ph.pinnedAt.IsZero() {
+		ph.pinnedAt = time.Now()
+	}
+
+	for _, pin := range policy.Pins {
+		ph.m[pin] = true
+	}
+	for _, pin := range policy.BackupPins {
+		ph.backup[pin] = true
+	}
+
+	p.mu.Lock()
+	p.hosts[normalizePinHost(policy.Host)] = ph
+	p.mu.Unlock()
+
+	return nil
+}
+
+// Policies returns the policies of the hosts whose pins did not expire.
+func (p *PinManager) Policies() []PinPolicy {
+	now := time.Now()
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	policies := make([]PinPolicy, 0, len(p.hosts))
+	for host, ph := range p.hosts {
+		if !ph.expired(now) {
+			policies = append(policies, ph.policy(host))
+		}
+	}
+
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].Host < policies[j].Host
+	})
+
+	return policies
+}
+
+// Save writes the policies of the manager to w as JSON.
+func (p *PinManager) Save(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p.Policies())
+}
+
+// Load reads policies written by Save from r and sets them.
+func (p *PinManager) Load(r io.Reader) error {
+	var policies []PinPolicy
+	if err := json.NewDecoder(r).Decode(&policies); err != nil {
+		return err
+	}
+
+	for _, policy := range policies {
+		if err := p.SetPolicy(policy); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SaveFile writes the policies of the manager to the file name.
+func (p *PinManager) SaveFile(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+
+	if err = p.Save(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// LoadFile reads the policies of the file name, written by SaveFile.
+func (p *PinManager) LoadFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return p.Load(f)
+}
+
+// lookup returns the unexpired pins of host, or of a parent domain including
+// its subdomains. p.mu must be held.
+func (p *PinManager) lookup(host string, now time.Time) *PinHost {
+	if ph, ok := p.hosts[host]; ok && !ph.expired(now) {
+		return ph
+	}
+
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return nil
+	}
+
+	for i := strings.IndexByte(hostname, '.'); i >= 0; i = strings.IndexByte(hostname, '.') {
+		hostname = hostname[i+1:]
+
+		ph, ok := p.hosts[net.JoinHostPort(hostname, port)]
+		if ok && ph.includeSubdomains && !ph.expired(now) {
+			return ph
+		}
+	}
+
+	return nil
+}
+
+// hostPins returns the unexpired pins applying to host.
+func (p *PinManager) hostPins(host string) *PinHost {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.lookup(host, time.Now())
+}
+
+// pinFailure reports the chain of host matching none of its pins to
+// OnPinFailure, and returns the error failing the handshake unless ReportOnly.
+func (p *PinManager) pinFailure(host string, verifiedChains [][]*x509.Certificate) error {
+	if p.OnPinFailure != nil {
+		var chain []*x509.Certificate
+		if len(verifiedChains) > 0 {
+			chain = verifiedChains[0]
+		}
+		p.OnPinFailure(host, chain)
+	}
+
+	if p.ReportOnly {
+		return nil
+	}
+
+	return errors.New("pin verification failed for " + host)
+}
@@ -40,7 +40,7 @@ func (s *Session) GetBrowserHTTP3ClientHelloFunc(browser string) func() *tls.Cli
 }
 
 // GetLastChromeVersion apply the latest Chrome version
-// Current Chrome version : 133
+// Current Chrome version : 135 (same ClientHello as 133)
 func GetLastChromeVersion() *tls.ClientHelloSpec {
 	return &tls.ClientHelloSpec{
 		CipherSuites: []uint16{
@@ -126,6 +126,7 @@ func GetLastChromeVersion() *tls.ClientHelloSpec {
 			},
 			&tls.UtlsGREASEExtension{},
 			&tls.UtlsPaddingExtension{GetPaddingLen: tls.BoringPaddingStyle},
+			&tls.UtlsPreSharedKeyExtension{},
 		}),
 	}
 }
@@ -236,6 +237,7 @@ func GetLastChromeVersionForHTTP3() *tls.ClientHelloSpec {
 					tls.VersionTLS13,
 				},
 			},
+			&tls.UtlsPreSharedKeyExtension{},
 		}),
 	}
 }
@@ -349,6 +351,7 @@ func GetLastIosVersion() *tls.ClientHelloSpec {
 			&tls.UtlsPaddingExtension{
 				GetPaddingLen: tls.BoringPaddingStyle,
 			},
+			&tls.UtlsPreSharedKeyExtension{},
 		},
 	}
 }
@@ -430,6 +433,7 @@ func GetLastSafariVersion() *tls.ClientHelloSpec {
 			}},
 			&tls.UtlsGREASEExtension{},
 			&tls.UtlsPaddingExtension{GetPaddingLen: tls.BoringPaddingStyle},
+			&tls.UtlsPreSharedKeyExtension{},
 		},
 	}
 }
@@ -541,6 +545,7 @@ func GetLastFirefoxVersion() *tls.ClientHelloSpec {
 				},
 				CandidatePayloadLens: []uint16{128, 223}, // +16: 144, 239
 			},
+			&tls.UtlsPreSharedKeyExtension{},
 		},
 	}
 }
@@ -294,7 +294,10 @@ func (c *proxyDialer) connectToProxy(ctx context.Context, proxyURL *url.URL, net
 				NextProtos:         []string{"h2", "http/1.1"},
 				ServerName:         proxyURL.Hostname(),
 				InsecureSkipVerify: true,
+				KeyLogWriter:       c.sess.keyLogWriter(),
 			}
+			tlsConf.GetClientCertificate = c.sess.clientCertificate(tlsConf.ServerName)
+			c.sess.applyProxyRootCAs(&tlsConf)
 			tlsConn, err := tls.Dial(network, proxyURL.Host, &tlsConf)
 			if err != nil {
 				return nil, "", err
@@ -495,7 +498,11 @@ func (c *proxyDialer) upgradeToTLS(conn net.Conn, proxyURL *url.URL) (net.Conn,
 		NextProtos:         []string{"h2", "http/1.1"},
 		ServerName:         proxyURL.Hostname(),
 		InsecureSkipVerify: true,
+		KeyLogWriter:       c.sess.keyLogWriter(),
+		OmitEmptyPsk:       true,
 	}
+	tlsConf.GetClientCertificate = c.sess.clientCertificate(tlsConf.ServerName)
+	c.sess.applyProxyRootCAs(&tlsConf)
 	tlsConn := tls.UClient(conn, &tlsConf, tls.HelloCustom)
 
 	// Apply TLS fingerprint
@@ -28,6 +28,8 @@ func (s *Session) prepareRequest(request *Request, args ...any) error {
 			request.Header = i
 		case HeaderOrder:
 			request.HeaderOrder = i
+		case RequestMode:
+			request.Mode = i
 		case time.Duration:
 			request.TimeOut = i
 		case context.Context:
@@ -110,7 +112,8 @@ func (s *Session) fillEmptyValues(request *Request) {
 }
 
 func (s *Session) buildRequest(ctx context.Context, req *Request) (err error) {
-	req.HttpRequest, err = newRequest(ctx, s.Verbose || s.VerboseFunc != nil, req)
+	logBody := s.logging && s.logConfig != nil && s.logConfig.Body
+	req.HttpRequest, err = newRequest(ctx, s.Verbose || s.VerboseFunc != nil || logBody, req)
 
 	req.browser = s.Browser
 	req.ua = s.UserAgent
@@ -123,11 +126,15 @@ func (s *Session) buildRequest(ctx context.Context, req *Request) (err error) {
 		req.PHeader = s.PHeader
 	}
 
+	if req.Mode != "" {
+		s.applyRequestMode(req)
+	}
+
 	req.disableDecompression = s.DisableAutoDecompression
 	req.formatHeader()
 
 	if !req.NoCookie {
-		cookies := s.CookieJar.Cookies(req.HttpRequest.URL)
+		cookies := s.requestCookies(req, req.HttpRequest.URL)
 		if cookies != nil && len(cookies) > 0 {
 			if c := req.HttpRequest.Header.Get("Cookie"); c != "" {
 				req.HttpRequest.Header.Set("Cookie", c+"; "+CookiesToString(cookies))
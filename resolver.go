@@ -0,0 +1,556 @@
+package azuretls
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	http "github.com/Noooste/fhttp"
+	tls "github.com/Noooste/utls"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Resolver resolves the hostnames of the connections of a session, network
+// being "ip", "ip4" or "ip6". *net.Resolver implements it.
+type Resolver interface {
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+}
+
+// UDPResolver is a Resolver querying a DNS server over UDP, retrying over
+// TCP when the response is truncated.
+type UDPResolver struct {
+	// Addr of the DNS server, e.g. 1.1.1.1:53. The port defaults to 53.
+	Addr string
+}
+
+// DoTResolver is a Resolver querying a DNS server over TLS (RFC 7858).
+type DoTResolver struct {
+	// Addr of the DNS server, e.g. 1.1.1.1:853. The port defaults to 853.
+	Addr string
+	// ServerName verified in the certificate of the server, e.g. cloudflare-dns.com.
+	// If empty, the host of Addr is used.
+	ServerName string
+	// Config of the TLS connections. If nil, a default config is used.
+	Config *tls.Config
+}
+
+// DoHResolver is a Resolver querying a DNS over HTTPS endpoint (RFC 8484)
+// through an azuretls session, so that the queries carry its fingerprint.
+type DoHResolver struct {
+	// URL of the DoH endpoint, e.g. https://cloudflare-dns.com/dns-query.
+	URL string
+	// Session sending the queries. It must not use the DoHResolver itself.
+	// If nil, a new Session is used.
+	Session *Session
+
+	once sync.Once
+}
+
+// NewDoHResolver returns a DoHResolver querying the DoH endpoint url.
+func NewDoHResolver(url string) *DoHResolver {
+	return &DoHResolver{
+		URL:     url,
+		Session: NewSession(),
+	}
+}
+
+// CachingResolver caches the addresses returned by a Resolver for the TTL of
+// their records, or TTL for resolvers not reporting it, like *net.Resolver.
+type CachingResolver struct {
+	// Resolver looking up the hosts missing from the cache. If nil, net.DefaultResolver is used.
+	Resolver Resolver
+	// TTL of the addresses returned by resolvers not reporting it. Defaults to 1 minute.
+	TTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]resolverCacheEntry
+}
+
+type resolverCacheEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// NewCachingResolver returns a CachingResolver caching the addresses of r.
+func NewCachingResolver(r Resolver) *CachingResolver {
+	return &CachingResolver{
+		Resolver: r,
+		cache:    make(map[string]resolverCacheEntry),
+	}
+}
+
+// ttlResolver is implemented by the resolvers reporting the TTL of the records.
+type ttlResolver interface {
+	lookupIPTTL(ctx context.Context, network, host string) ([]net.IP, time.Duration, error)
+}
+
+// dnsExchanger sends a DNS query and returns the response.
+type dnsExchanger interface {
+	exchange(ctx context.Context, query []byte) ([]byte, error)
+}
+
+// LookupIP implements Resolver.
+func (r *UDPResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	ips, _, err := lookupIP(ctx, r, network, host)
+	return ips, err
+}
+
+func (r *UDPResolver) lookupIPTTL(ctx context.Context, network, host string) ([]net.IP, time.Duration, error) {
+	return lookupIP(ctx, r, network, host)
+}
+
+func (r *UDPResolver) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	addr := dnsServerAddr(r.Addr, "53")
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(dnsDeadline(ctx))
+
+	if _, err = conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		// ignore responses to other queries
+		if n < 12 || buf[0] != query[0] || buf[1] != query[1] {
+			continue
+		}
+
+		// the TC bit is set when the response did not fit in a datagram
+		if buf[2]&0x02 == 0 {
+			return buf[:n], nil
+		}
+
+		break
+	}
+
+	tcpConn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer tcpConn.Close()
+
+	_ = tcpConn.SetDeadline(dnsDeadline(ctx))
+
+	return exchangeStream(tcpConn, query)
+}
+
+// LookupIP implements Resolver.
+func (r *DoTResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	ips, _, err := lookupIP(ctx, r, network, host)
+	return ips, err
+}
+
+func (r *DoTResolver) lookupIPTTL(ctx context.Context, network, host string) ([]net.IP, time.Duration, error) {
+	return lookupIP(ctx, r, network, host)
+}
+
+func (r *DoTResolver) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	addr := dnsServerAddr(r.Addr, "853")
+
+	var config *tls.Config
+	if r.Config != nil {
+		config = r.Config.Clone()
+	} else {
+		config = &tls.Config{}
+	}
+
+	if config.ServerName == "" {
+		config.ServerName = r.ServerName
+	}
+	if config.ServerName == "" {
+		config.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, config)
+	defer tlsConn.Close()
+
+	_ = tlsConn.SetDeadline(dnsDeadline(ctx))
+
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, fmt.Errorf("failed to handshake with %s: %w", addr, err)
+	}
+
+	return exchangeStream(tlsConn, query)
+}
+
+// LookupIP implements Resolver.
+func (r *DoHResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	ips, _, err := lookupIP(ctx, r, network, host)
+	return ips, err
+}
+
+func (r *DoHResolver) lookupIPTTL(ctx context.Context, network, host string) ([]net.IP, time.Duration, error) {
+	return lookupIP(ctx, r, network, host)
+}
+
+func (r *DoHResolver) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	r.once.Do(func() {
+		if r.Session == nil {
+			r.Session = NewSession()
+		}
+	})
+
+	return dohExchange(ctx, r.Session, r.URL, query)
+}
+
+// LookupIP implements Resolver.
+func (r *CachingResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	key := network + "/" + strings.ToLower(strings.TrimSuffix(host, "."))
+
+	r.mu.Lock()
+	if entry, ok := r.cache[key]; ok && time.Now().Before(entry.expires) {
+		r.mu.Unlock()
+		return entry.ips, nil
+	}
+	r.mu.Unlock()
+
+	var (
+		ips []net.IP
+		ttl = r.TTL
+		err error
+	)
+
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+
+	switch resolver := r.Resolver.(type) {
+	case nil:
+		ips, err = net.DefaultResolver.LookupIP(ctx, network, host)
+	case ttlResolver:
+		ips, ttl, err = resolver.lookupIPTTL(ctx, network, host)
+	default:
+		ips, err = resolver.LookupIP(ctx, network, host)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	if r.cache == nil {
+		r.cache = make(map[string]resolverCacheEntry)
+	}
+	r.cache[key] = resolverCacheEntry{
+		ips:     ips,
+		expires: time.Now().Add(ttl),
+	}
+	r.mu.Unlock()
+
+	return ips, nil
+}
+
+// Flush removes every address from the cache.
+func (r *CachingResolver) Flush() {
+	r.mu.Lock()
+	r.cache = make(map[string]resolverCacheEntry)
+	r.mu.Unlock()
+}
+
+// lookupIP resolves host with the A and AAAA queries of network sent with e,
+// returning the IPv4 addresses first and the lowest TTL of the records.
+func lookupIP(ctx context.Context, e dnsExchanger, network, host string) ([]net.IP, time.Duration, error) {
+	var types []dnsmessage.Type
+
+	switch network {
+	case "ip":
+		types = []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	case "ip4":
+		types = []dnsmessage.Type{dnsmessage.TypeA}
+	case "ip6":
+		types = []dnsmessage.Type{dnsmessage.TypeAAAA}
+	default:
+		return nil, 0, errors.New("unsupported network " + network)
+	}
+
+	type result struct {
+		ips []net.IP
+		ttl uint32
+		err error
+	}
+
+	results := make([]result, len(types))
+
+	var wg sync.WaitGroup
+	for i, qtype := range types {
+		wg.Add(1)
+		go func(i int, qtype dnsmessage.Type) {
+			defer wg.Done()
+			results[i].ips, results[i].ttl, results[i].err = lookupType(ctx, e, host, qtype)
+		}(i, qtype)
+	}
+	wg.Wait()
+
+	var (
+		ips      []net.IP
+		ttl      uint32
+		firstErr error
+	)
+
+	for _, result := range results {
+		if result.err != nil {
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			continue
+		}
+
+		if len(result.ips) > 0 && (ips == nil || result.ttl < ttl) {
+			ttl = result.ttl
+		}
+		ips = append(ips, result.ips...)
+	}
+
+	if len(ips) == 0 {
+		if firstErr != nil {
+			return nil, 0, &net.DNSError{Err: firstErr.Error(), Name: host}
+		}
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	return ips, time.Duration(ttl) * time.Second, nil
+}
+
+// lookupType returns the addresses of the records of type qtype of host.
+func lookupType(ctx context.Context, e dnsExchanger, host string, qtype dnsmessage.Type) ([]net.IP, uint32, error) {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var id [2]byte
+	if _, err = rand.Read(id[:]); err != nil {
+		return nil, 0, err
+	}
+
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:]), RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: qtype, Class: dnsmessage.ClassINET},
+		},
+	}
+
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	msg, err := e.exchange(ctx, packed)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var p dnsmessage.Parser
+
+	header, err := p.Start(msg)
+	if err != nil {
+		return nil, 0, err
+	}
+	if header.ID != query.ID {
+		return nil, 0, errors.New("DNS response does not match the query")
+	}
+	if header.RCode == dnsmessage.RCodeNameError {
+		return nil, 0, nil
+	}
+	if header.RCode != dnsmessage.RCodeSuccess {
+		return nil, 0, fmt.Errorf("DNS query failed: %s", header.RCode)
+	}
+	if err = p.SkipAllQuestions(); err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		ips []net.IP
+		ttl uint32
+	)
+
+	for {
+		h, err := p.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var ip net.IP
+
+		switch {
+		case h.Type == dnsmessage.TypeA && qtype == dnsmessage.TypeA:
+			record, err := p.AResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			ip = net.IP(record.A[:])
+		case h.Type == dnsmessage.TypeAAAA && qtype == dnsmessage.TypeAAAA:
+			record, err := p.AAAAResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			ip = net.IP(record.AAAA[:])
+		default:
+			// e.g. the CNAME records leading to the addresses
+			if err = p.SkipAnswer(); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+
+		if ips == nil || h.TTL < ttl {
+			ttl = h.TTL
+		}
+		ips = append(ips, ip)
+	}
+
+	return ips, ttl, nil
+}
+
+// exchangeStream sends query over a stream connection, prefixed by its length.
+func exchangeStream(conn net.Conn, query []byte) ([]byte, error) {
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+
+	response := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// dnsServerAddr adds the default port to the address of a DNS server.
+func dnsServerAddr(addr, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return net.JoinHostPort(strings.Trim(addr, "[]"), port)
+	}
+	return addr
+}
+
+// dnsDeadline returns the deadline of ctx, or 5 seconds from now.
+func dnsDeadline(ctx context.Context) time.Time {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline
+	}
+	return time.Now().Add(5 * time.Second)
+}
+
+// dohExchange sends query to the DoH endpoint url through session.
+func dohExchange(ctx context.Context, session *Session, url string, query []byte) ([]byte, error) {
+	// the values of ctx (options and trace of the request being dialed) must
+	// not apply to the request of the resolver
+	ctx, cancel := detachedContext(ctx)
+	defer cancel()
+
+	response, err := session.Post(url, query, OrderedHeaders{
+		{"accept", "application/dns-message"},
+		{"content-type", "application/dns-message"},
+	}, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH query to %s failed: %d", url, response.StatusCode)
+	}
+
+	return response.Body, nil
+}
+
+// detachedContext returns a context with the deadline and the cancellation
+// of ctx, but none of its values.
+func detachedContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	var (
+		detached context.Context
+		cancel   context.CancelFunc
+	)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		detached, cancel = context.WithDeadline(context.Background(), deadline)
+	} else {
+		detached, cancel = context.WithCancel(context.Background())
+	}
+
+	stop := context.AfterFunc(ctx, cancel)
+
+	return detached, func() {
+		stop()
+		cancel()
+	}
+}
+
+// resolveAddrs returns the addresses to dial for addr in the order of
+// AddressFamily, from HostOverrides or resolved with the Resolver of the
+// session, or the system resolver.
+func (s *Session) resolveAddrs(ctx context.Context, network, addr string) ([]string, error) {
+	if addrs := s.hostOverrides(addr); addrs != nil {
+		return addrs, nil
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if net.ParseIP(host) != nil {
+		return []string{addr}, nil
+	}
+
+	var resolver Resolver = net.DefaultResolver
+	if s.Resolver != nil {
+		resolver = s.Resolver
+	}
+
+	ips, err := resolver.LookupIP(ctx, s.ipNetwork(network), host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	ips = s.sortIPs(ips)
+
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip.String(), port)
+	}
+
+	return addrs, nil
+}
@@ -28,6 +28,12 @@ func (s *Session) buildResponse(response *Response, httpResponse *http.Response)
 	response.Status = httpResponse.Status
 	response.Header = headers
 
+	if httpResponse.TLS != nil {
+		response.ECHAccepted = httpResponse.TLS.ECHAccepted
+
+		response.CertificateStatus = s.certificateStatus(httpResponse.TLS)
+	}
+
 	encoding := httpResponse.Header.Get("Content-Encoding")
 
 	if !response.IgnoreBody {
@@ -55,7 +61,7 @@ func (s *Session) buildResponse(response *Response, httpResponse *http.Response)
 
 			if !response.Request.NoCookie {
 				cookies := httpResponse.Cookies()
-				s.CookieJar.SetCookies(u, cookies)
+				s.setResponseCookies(response, u, cookies)
 				response.Cookies = GetCookiesMap(cookies)
 			}
 
@@ -83,7 +89,7 @@ func (s *Session) buildResponse(response *Response, httpResponse *http.Response)
 
 			if !response.Request.NoCookie {
 				cookies := httpResponse.Cookies()
-				s.CookieJar.SetCookies(u, cookies)
+				s.setResponseCookies(response, u, cookies)
 				response.Cookies = GetCookiesMap(cookies)
 			}
 
@@ -101,7 +107,7 @@ func (s *Session) buildResponse(response *Response, httpResponse *http.Response)
 
 		if !response.Request.NoCookie {
 			cookies := httpResponse.Cookies()
-			s.CookieJar.SetCookies(u, cookies)
+			s.setResponseCookies(response, u, cookies)
 			response.Cookies = GetCookiesMap(cookies)
 		}
 
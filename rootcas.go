@@ -0,0 +1,115 @@
+package azuretls
+
+import (
+	"crypto/x509"
+	"errors"
+	"os"
+
+	tls "github.com/Noooste/utls"
+)
+
+// LoadCertPool returns a pool of the certificates of the PEM files, e.g. to
+// set Session.RootCAs or Session.AdditionalRootCAs.
+func LoadCertPool(files ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("no certificate found in " + file)
+		}
+	}
+
+	return pool, nil
+}
+
+// verifyChains verifies the certificates presented for dnsName against
+// RootCAs, or the system roots if nil, then against AdditionalRootCAs.
+func (s *Session) verifyChains(certs []*x509.Certificate, dnsName string) ([][]*x509.Certificate, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("tls: server sent no certificate")
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         s.RootCAs,
+		DNSName:       dnsName,
+		Intermediates: x509.NewCertPool(),
+	}
+
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	chains, err := certs[0].Verify(opts)
+	if err == nil || s.AdditionalRootCAs == nil {
+		return chains, err
+	}
+
+	opts.Roots = s.AdditionalRootCAs
+	if chains, additionalErr := certs[0].Verify(opts); additionalErr == nil {
+		return chains, nil
+	}
+
+	return nil, err
+}
+
+// hasRootCAs reports whether the session trusts other roots than the system ones.
+func (s *Session) hasRootCAs() bool {
+	return s.RootCAs != nil || s.AdditionalRootCAs != nil
+}
+
+// applyRootCAs makes config verify the certificates of hostname against the
+// roots of the session. As the TLS stack only takes a single pool and verifies
+// the server name it sends, the verification is done by VerifyPeerCertificate
+// when AdditionalRootCAs is set or hostname is not the server name of config.
+func (s *Session) applyRootCAs(config *tls.Config, hostname string) {
+	if config.InsecureSkipVerify {
+		return
+	}
+
+	config.RootCAs = s.RootCAs
+
+	if s.AdditionalRootCAs == nil && config.ServerName == hostname {
+		return
+	}
+
+	verify := config.VerifyPeerCertificate
+
+	config.InsecureSkipVerify = true
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		certs := make([]*x509.Certificate, 0, len(rawCerts))
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			certs = append(certs, cert)
+		}
+
+		chains, err := s.verifyChains(certs, hostname)
+		if err != nil {
+			return &tls.CertificateVerificationError{UnverifiedCertificates: certs, Err: err}
+		}
+
+		if verify != nil {
+			return verify(rawCerts, chains)
+		}
+
+		return nil
+	}
+}
+
+// applyProxyRootCAs verifies the certificate of HTTPS proxies, which is not
+// verified by default, when the session trusts its own roots.
+func (s *Session) applyProxyRootCAs(config *tls.Config) {
+	if s.InsecureSkipVerify || !s.hasRootCAs() {
+		return
+	}
+
+	config.InsecureSkipVerify = false
+	s.applyRootCAs(config, config.ServerName)
+}
@@ -0,0 +1,69 @@
+package azuretls
+
+import (
+	"context"
+	"net"
+
+	tls "github.com/Noooste/utls"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// serverNameOptions are the SNI options of a request, see Request.ServerName.
+type serverNameOptions struct {
+	serverName     string
+	omitServerName bool
+	verifyName     string
+}
+
+// serverNames returns the SNI sent to hostname by the connections of ctx, and
+// the name their certificate is verified against.
+func (s *Session) serverNames(ctx context.Context, hostname string) (serverName, verifyName string) {
+	opts, _ := ctx.Value(serverNameKey).(serverNameOptions)
+
+	serverName = hostname
+	if opts.serverName != "" {
+		serverName = opts.serverName
+	}
+	if opts.omitServerName {
+		serverName = ""
+	}
+
+	switch {
+	case opts.verifyName != "":
+		verifyName = opts.verifyName
+	case s.VerifyName != nil:
+		verifyName = s.VerifyName(hostname, serverName)
+	}
+
+	if verifyName == "" {
+		verifyName = serverName
+	}
+	if verifyName == "" {
+		verifyName = hostname
+	}
+
+	return serverName, verifyName
+}
+
+// applyServerName replaces the server_name extension of spec by one carrying
+// serverName when it is an IP, which the TLS stack does not send.
+func applyServerName(spec *tls.ClientHelloSpec, serverName string) {
+	if spec == nil || net.ParseIP(serverName) == nil {
+		return
+	}
+
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint8(0) // host_name
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes([]byte(serverName))
+		})
+	})
+
+	for i, ext := range spec.Extensions {
+		if _, ok := ext.(*tls.SNIExtension); ok {
+			spec.Extensions[i] = &tls.GenericExtension{Id: 0, Data: b.BytesOrPanic()}
+			return
+		}
+	}
+}
@@ -12,7 +12,7 @@ import (
 	"time"
 
 	http "github.com/Noooste/fhttp"
-	"github.com/Noooste/fhttp/cookiejar"
+	"github.com/Noooste/fhttp/httptrace"
 )
 
 // NewSession creates a new session
@@ -24,12 +24,10 @@ func NewSession() *Session {
 // NewSessionWithContext creates a new session with context
 // It is recommended to use this function to create a new session instead of creating a new Session struct
 func NewSessionWithContext(ctx context.Context) *Session {
-	cookieJar, _ := cookiejar.New(nil)
-
 	s := &Session{
 		OrderedHeaders: make(OrderedHeaders, 0),
 
-		CookieJar: cookieJar,
+		CookieJar: NewCookieJar(),
 		Browser:   Chrome,
 
 		UserAgent: defaultUserAgent,
@@ -142,9 +140,20 @@ func (s *Session) send(request *Request) (response *Response, err error) {
 		request.ctx = context.WithValue(request.ctx, insecureSkipVerifyKey, true)
 	}
 
-	request.HttpRequest = request.HttpRequest.WithContext(request.ctx)
+	// the context of a redirect carries the options of the previous request
+	if request.ServerName != "" || request.OmitServerName || request.VerifyName != "" || request.ctx.Value(serverNameKey) != nil {
+		request.ctx = context.WithValue(request.ctx, serverNameKey, serverNameOptions{
+			serverName:     request.ServerName,
+			omitServerName: request.OmitServerName,
+			verifyName:     request.VerifyName,
+		})
+	}
+
+	trace, localAddr := localAddrTrace()
+	request.HttpRequest = request.HttpRequest.WithContext(httptrace.WithClientTrace(request.ctx, trace))
 
 	httpResponse, err = roundTripper.RoundTrip(request.HttpRequest)
+	response.LocalAddr = localAddr()
 
 	if err != nil {
 		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
@@ -164,6 +173,8 @@ func (s *Session) send(request *Request) (response *Response, err error) {
 			err = fmt.Errorf("read body: timeout")
 		}
 
+		s.logResponse(response, err)
+
 		return nil, err
 	}
 
@@ -194,6 +205,10 @@ func (s *Session) do(req *Request, args ...any) (resp *Response, err error) {
 		return
 	}
 
+	if err = s.checkFingerprint(req); err != nil {
+		return
+	}
+
 	if req.ctx == nil {
 		req.ctx = s.ctx
 	}
@@ -216,12 +231,13 @@ func (s *Session) do(req *Request, args ...any) (resp *Response, err error) {
 
 	if req.DisableRedirects {
 		req.startTime = time.Now()
-		resp, err = s.send(req)
+		resp, err = s.sendWithClientHints(req)
 		if err != nil {
 			return
 		}
 		req.CloseBody()
 		req.Response = resp
+		s.trackDocument(req)
 		return
 	}
 
@@ -270,13 +286,25 @@ func (s *Session) do(req *Request, args ...any) (resp *Response, err error) {
 				ctx:                oldReq.ctx,
 				deadline:           oldReq.deadline,
 				MaxRedirects:       oldReq.MaxRedirects,
+				Initiator:          oldReq.Initiator,
+				Subresource:        oldReq.Subresource,
+				Mode:               oldReq.Mode,
+				fetchSite:          oldReq.fetchSite,
+			}
+
+			// the server name of a request only applies to its host
+			if u.Host == oldReq.parsedUrl.Host {
+				req.ServerName = oldReq.ServerName
+				req.OmitServerName = oldReq.OmitServerName
+				req.VerifyName = oldReq.VerifyName
 			}
 
 			copyHeaders(req)
 
 			// Add the Referer header from the first
-			// request URL to the new one, if it's not https->http:
-			if ref := RefererForURL(ireq.parsedUrl, req.parsedUrl); ref != "" {
+			// request URL to the new one, if it's not https->http.
+			// With a Mode, the Referer of the initiator is generated for each hop.
+			if ref := RefererForURL(ireq.parsedUrl, req.parsedUrl); ref != "" && req.Mode == "" {
 				if req.OrderedHeaders != nil {
 					if req.OrderedHeaders.Get("Referer") == "" || req.OrderedHeaders.Get("referer") == "" {
 						req.OrderedHeaders.Set("Referer", ref)
@@ -335,13 +363,18 @@ func (s *Session) do(req *Request, args ...any) (resp *Response, err error) {
 			}
 
 			s.fillEmptyValues(req)
+
+			if s.Observer != nil {
+				s.Observer.Redirected(req)
+			}
 		}
 
+		req.redirectIndex = len(reqs)
 		reqs = append(reqs, req)
 
 		req.startTime = time.Now()
 
-		if resp, err = s.send(req); err != nil {
+		if resp, err = s.sendWithClientHints(req); err != nil {
 			return nil, err
 		}
 
@@ -351,6 +384,7 @@ func (s *Session) do(req *Request, args ...any) (resp *Response, err error) {
 
 		redirectMethod, shouldRedirect, includeBody = RedirectBehavior(req.Method, resp, reqs[0])
 		if !shouldRedirect {
+			s.trackDocument(req)
 			return resp, nil
 		}
 
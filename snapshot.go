@@ -0,0 +1,248 @@
+package azuretls
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	tls "github.com/Noooste/utls"
+)
+
+// SnapshotVersion is the version of the format written by Session.Snapshot.
+const SnapshotVersion = 2
+
+// appliedJa3 is the JA3 fingerprint applied with ApplyJa3 or ApplyJa3WithSpecifications.
+type appliedJa3 struct {
+	Fingerprint    string             `json:"fingerprint"`
+	Navigator      string             `json:"navigator"`
+	Specifications *TlsSpecifications `json:"specifications,omitempty"`
+}
+
+type sessionSnapshot struct {
+	Version int `json:"version"`
+
+	Browser        string         `json:"browser"`
+	UserAgent      string         `json:"user_agent"`
+	PHeader        PHeader        `json:"pheader,omitempty"`
+	OrderedHeaders OrderedHeaders `json:"ordered_headers,omitempty"`
+
+	Cookies []*JarCookie `json:"cookies,omitempty"`
+
+	JA3   *appliedJa3 `json:"ja3,omitempty"`
+	HTTP2 string      `json:"http2,omitempty"`
+	HTTP3 string      `json:"http3,omitempty"`
+
+	ShuffleExtensions bool `json:"shuffle_extensions,omitempty"`
+
+	HTTP3Enabled bool     `json:"http3_enabled,omitempty"`
+	ForceHTTP3   bool     `json:"force_http3,omitempty"`
+	AltSvc       []string `json:"alt_svc,omitempty"`
+
+	Proxy      string   `json:"proxy,omitempty"`
+	ProxyChain []string `json:"proxy_chain,omitempty"`
+	H2Proxy    bool     `json:"h2_proxy,omitempty"`
+
+	PinPolicies []PinPolicy `json:"pin_policies,omitempty"`
+	// Pins are the pins of version 1 snapshots, by host.
+	Pins map[string][]string `json:"pins,omitempty"`
+
+	// Tickets is nil if the ClientSessionCache of the session is not a TicketStore.
+	Tickets []SessionTicket `json:"tickets"`
+}
+
+// SessionTicket is a serialized TLS session of a ClientSessionCache.
+type SessionTicket struct {
+	Key    string `json:"key"`
+	Ticket []byte `json:"ticket"`
+	State  []byte `json:"state"`
+}
+
+// TicketStore is a tls.ClientSessionCache whose sessions can be exported
+// and imported, which Snapshot and RestoreSession rely on.
+type TicketStore interface {
+	tls.ClientSessionCache
+
+	// Export returns the sessions of the cache which can be serialized.
+	Export() []SessionTicket
+	// Import adds sessions returned by Export to the cache.
+	Import(tickets []SessionTicket) error
+}
+
+// Snapshot serializes the identity of the session to JSON so that it can be
+// restored later with RestoreSession: cookies of every domain, headers,
+// browser and user agent, the fingerprints applied with ApplyJa3, ApplyHTTP2
+// and ApplyHTTP3, the proxy configuration, the pin policies of its PinManager
+// unless it is the shared DefaultPinManager, the Alt-Svc cache and the TLS
+// sessions of its ClientSessionCache if it is a TicketStore.
+//
+// Functions (hooks, GetClientHelloSpec set directly, dialers) are not part of
+// the snapshot. The snapshot contains secrets such as cookies and proxy
+// credentials and must be stored accordingly.
+func (s *Session) Snapshot() ([]byte, error) {
+	snapshot := &sessionSnapshot{
+		Version:        SnapshotVersion,
+		Browser:        s.Browser,
+		UserAgent:      s.UserAgent,
+		PHeader:        s.PHeader,
+		OrderedHeaders: s.OrderedHeaders,
+		JA3:            s.ja3,
+		HTTP2:          s.http2Fingerprint,
+		HTTP3:          s.http3Fingerprint,
+		H2Proxy:        s.H2Proxy,
+
+		ShuffleExtensions: s.ShuffleExtensions,
+	}
+
+	if jar, ok := s.CookieJar.(*CookieJar); ok {
+		snapshot.Cookies = jar.All()
+	} else if s.CookieJar != nil {
+		return nil, errors.New("cookie jar does not support snapshots, use NewCookieJar")
+	}
+
+	if s.HTTP3Config != nil {
+		snapshot.HTTP3Enabled = s.HTTP3Config.Enabled
+		snapshot.ForceHTTP3 = s.HTTP3Config.ForceHTTP3
+
+		s.HTTP3Config.altSvcCache.Range(func(key, _ any) bool {
+			snapshot.AltSvc = append(snapshot.AltSvc, key.(string))
+			return true
+		})
+		sort.Strings(snapshot.AltSvc)
+	}
+
+	if s.IsChainProxy() {
+		for _, u := range s.ProxyDialer.ProxyChain {
+			snapshot.ProxyChain = append(snapshot.ProxyChain, u.String())
+		}
+	} else {
+		snapshot.Proxy = s.Proxy
+	}
+
+	// the pins of DefaultPinManager belong to every session of the process
+	if s.PinManager != nil && s.PinManager != DefaultPinManager {
+		snapshot.PinPolicies = s.PinManager.Policies()
+	}
+
+	if cache, ok := s.ClientSessionCache.(TicketStore); ok {
+		snapshot.Tickets = cache.Export()
+	}
+
+	return json.Marshal(snapshot)
+}
+
+// RestoreSession creates a new session from the output of Session.Snapshot.
+//
+// If the snapshot holds pins, the session gets its own PinManager instead of
+// DefaultPinManager, so that restored pins do not leak to other sessions.
+func RestoreSession(snapshot []byte) (*Session, error) {
+	var snap sessionSnapshot
+	if err := json.Unmarshal(snapshot, &snap); err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %w", err)
+	}
+
+	if snap.Version < 1 || snap.Version > SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+
+	s := NewSession()
+
+	if err := s.restore(&snap); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Session) restore(snap *sessionSnapshot) error {
+	s.Browser = snap.Browser
+	s.UserAgent = snap.UserAgent
+	s.OrderedHeaders = snap.OrderedHeaders
+	s.H2Proxy = snap.H2Proxy
+	s.ShuffleExtensions = snap.ShuffleExtensions
+
+	jar := s.CookieJar.(*CookieJar)
+	for _, c := range snap.Cookies {
+		if err := jar.Set(c); err != nil {
+			return fmt.Errorf("invalid cookie %s: %w", c.Name, err)
+		}
+	}
+
+	if len(snap.PinPolicies) > 0 {
+		s.PinManager = NewPinManager()
+		for _, policy := range snap.PinPolicies {
+			if err := s.PinManager.SetPolicy(policy); err != nil {
+				return fmt.Errorf("invalid pin policy: %w", err)
+			}
+		}
+	} else if len(snap.Pins) > 0 {
+		s.PinManager = NewPinManager()
+		for host, pins := range snap.Pins {
+			s.PinManager.AddPins(host, pins)
+		}
+	}
+
+	// the snapshot was taken with a TicketStore, resumption stays enabled
+	if s.ClientSessionCache == nil && snap.Tickets != nil {
+		s.ClientSessionCache = NewTicketCache(0)
+	}
+
+	if cache, ok := s.ClientSessionCache.(TicketStore); ok && snap.Tickets != nil {
+		if err := cache.Import(snap.Tickets); err != nil {
+			return fmt.Errorf("invalid session ticket: %w", err)
+		}
+	}
+
+	if snap.JA3 != nil {
+		specifications := snap.JA3.Specifications
+		if specifications == nil {
+			specifications = DefaultTlsSpecifications(snap.JA3.Navigator)
+		}
+
+		if err := s.ApplyJa3WithSpecifications(snap.JA3.Fingerprint, specifications, snap.JA3.Navigator); err != nil {
+			return err
+		}
+	}
+
+	if snap.HTTP2 != "" {
+		if err := s.ApplyHTTP2(snap.HTTP2); err != nil {
+			return err
+		}
+	}
+
+	if snap.HTTP3 != "" {
+		if err := s.ApplyHTTP3(snap.HTTP3); err != nil {
+			return err
+		}
+	} else if snap.HTTP3Enabled {
+		if err := s.EnableHTTP3(); err != nil {
+			return err
+		}
+	}
+
+	if s.HTTP3Config != nil {
+		s.HTTP3Config.Enabled = snap.HTTP3Enabled
+		s.HTTP3Config.ForceHTTP3 = snap.ForceHTTP3
+
+		for _, host := range snap.AltSvc {
+			s.HTTP3Config.altSvcCache.Store(host, true)
+		}
+	}
+
+	// fingerprints overwrite the pseudo-header order, the snapshot has the final one
+	s.PHeader = snap.PHeader
+
+	switch {
+	case len(snap.ProxyChain) > 0:
+		if err := s.SetProxyChain(snap.ProxyChain); err != nil {
+			return err
+		}
+	case snap.Proxy != "":
+		if err := s.SetProxy(snap.Proxy); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -612,7 +612,7 @@ func (s *Session) dialQUICViaSocks5(ctx context.Context, remoteAddr *net.UDPAddr
 			Conn: packetConn,
 		},
 		QUICSpec: &quic.QUICSpec{
-			ClientHelloSpec:   s.GetBrowserHTTP3ClientHelloFunc(s.Browser)(),
+			ClientHelloSpec:   s.http3ClientHelloSpec(tlsConf.ServerName),
 			InitialPacketSpec: getInitialPacket(s.Browser),
 		},
 	}
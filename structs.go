@@ -2,8 +2,10 @@ package azuretls
 
 import (
 	"context"
+	"crypto/x509"
 	"errors"
 	"io"
+	"log/slog"
 	"net"
 	"net/url"
 	"regexp"
@@ -45,6 +47,17 @@ type Session struct {
 	// Name or identifier of the browser used in the session.
 	Browser string
 
+	// User-Agent Client Hints sent by Chromium based browsers. They are opt-in:
+	// the sec-ch-ua headers are only generated for requests with a Request.Mode,
+	// and high-entropy hints requested by Accept-CH or Critical-CH are only
+	// stored, and the request sent again, for ModeNavigate requests.
+	// If nil, they are derived from UserAgent, see NewClientHints.
+	ClientHints *ClientHints
+
+	// If true, requests fail with ErrInconsistentFingerprint when ValidateFingerprint
+	// reports inconsistencies between the user agent, TLS, HTTP/2, HTTP/3 and headers.
+	StrictFingerprint bool
+
 	Transport      *http.Transport
 	HTTP2Transport *http2.Transport
 	HTTP3Config    *HTTP3Config
@@ -55,6 +68,32 @@ type Session struct {
 	// Function to provide custom TLS handshake details for HTTP/3 connections.
 	GetClientHelloSpecHTTP3 func() *tls.ClientHelloSpec
 
+	// If true, the TLS extensions of every TCP and QUIC connection are permuted
+	// like Chrome does, keeping GREASE, padding and pre_shared_key in place.
+	// The JA3 hash changes for every connection while JA4 stays the same.
+	// The default Chrome profiles are already permuted for every connection; this
+	// is mostly useful with ApplyJa3 and GetClientHelloSpec, which keep their order.
+	// It is ignored for Firefox and Safari, which send a fixed order.
+	ShuffleExtensions bool
+
+	// ECHConfigs are the ECHConfigList, as published in the "ech" parameter of
+	// DNS HTTPS records, used to encrypt the ClientHello of TCP connections by hostname.
+	// The outer ClientHello keeps the profile: ECH is only offered by profiles sending
+	// an encrypted_client_hello extension, like Chrome and Firefox.
+	// Response.ECHAccepted reports whether the server accepted it.
+	ECHConfigs map[string][]byte
+	// ECHResolver looks up the ECHConfigList of hosts missing from ECHConfigs,
+	// see DoHECHResolver. If the lookup fails, the connection is made without ECH.
+	ECHResolver ECHResolver
+
+	// ClientCertificates are presented to servers requesting a client certificate
+	// (mutual TLS) on TCP, HTTPS proxy and QUIC connections: the first one supported
+	// by the server is sent. See LoadClientCertificate and LoadPKCS12ClientCertificate.
+	ClientCertificates []tls.Certificate
+	// GetClientCertificate selects the client certificate sent to host, e.g. to use
+	// a certificate per host. If it returns a nil certificate, ClientCertificates are used.
+	GetClientCertificate func(host string, info *tls.CertificateRequestInfo) (*tls.Certificate, error)
+
 	// Proxy address.
 	Proxy string
 	// If true, use HTTP2 for proxy connections.
@@ -88,9 +127,64 @@ type Session struct {
 	// Function called after receiving a response.
 	CallbacksWithContext []func(ctx *Context)
 
+	// CookiePolicy enables SameSite, Partitioned (CHIPS) and cookie prefix rules
+	// of browsers in the default CookieJar. If nil, only RFC 6265 rules apply.
+	// See BrowserCookiePolicy.
+	CookiePolicy *CookiePolicy
+
+	// OnCookieChange is called when Set-Cookie headers of a response add, update or
+	// expire a cookie of the session. It requires the default CookieJar.
+	OnCookieChange func(response *Response, cookie *JarCookie, change CookieChange)
+
+	// Observer receives connection, traffic and verification events.
+	// See the metrics package for a Prometheus implementation.
+	Observer Observer
+
 	// Function to modify the dialer used for establishing connections.
 	ModifyDialer func(dialer *net.Dialer) error
 
+	// TCPFingerprint sets the socket options shaping the TCP SYN of connections
+	// (TTL, MSS, window...), e.g. PlatformTCPFingerprint("Windows"). It is not
+	// used with Dial or proxies. Only supported on Linux: elsewhere, dials fail
+	// with ErrTCPFingerprintUnsupported.
+	TCPFingerprint *TCPFingerprint
+	// If true and TCPFingerprint is nil, the TCP fingerprint of the platform of
+	// the user agent of the request is used, see PlatformTCPFingerprint.
+	ShapeTCPFingerprint bool
+
+	// Resolver resolves the hostnames of TCP, QUIC and websocket connections,
+	// see UDPResolver, DoTResolver, DoHResolver and CachingResolver.
+	// If nil, the system resolver is used. It is not used with Dial or proxies.
+	Resolver Resolver
+
+	// AddressFamily selects the IP versions of the addresses of hosts, which are
+	// raced following RFC 8305 (Happy Eyeballs v2) by TCP and QUIC connections.
+	// Defaults to PreferIPv6.
+	AddressFamily AddressFamily
+	// HappyEyeballsDelay is the delay before the next address of a host is tried
+	// while the previous attempts are still running (Connection Attempt Delay).
+	// Defaults to 250ms. If negative, an address is only tried once the previous one failed.
+	// The attempts are reported to the ConnectStart and ConnectDone hooks of the
+	// httptrace.ClientTrace of the context of the request.
+	HappyEyeballsDelay time.Duration
+
+	// LocalAddrPool selects the source address of TCP and QUIC connections, e.g. to
+	// rotate over owned IPv4 addresses and an IPv6 prefix. It replaces the LocalAddr
+	// set by ModifyDialer and is not used with Dial or proxies.
+	// Response.LocalAddr reports the address used. See NewLocalAddrPool.
+	LocalAddrPool *LocalAddrPool
+
+	// HostOverrides maps a host:port, or a host for any port, to the addresses its
+	// connections are made to instead of resolving it, like curl --resolve.
+	// The addresses are IPs, with an optional port replacing the original one.
+	// Proxies and Dial receive the first address.
+	HostOverrides map[string][]string
+	// ConnectTo maps a host:port, or a host for any port, to the authority its
+	// connections are made to, like curl --connect-to, e.g. a staging server or a CDN edge.
+	// The SNI, the Host header and the certificate verification keep the original host.
+	// An empty host or port in the target keeps the original one, e.g. ":8443".
+	ConnectTo map[string]string
+
 	// Custom dial function for establishing connections.
 	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
 
@@ -124,6 +218,42 @@ type Session struct {
 	// If true, server's certificate is not verified (insecure: this may facilitate attack from middleman).
 	InsecureSkipVerify bool
 
+	// VerifyName returns the name the certificate of host is verified against when
+	// serverName is sent as SNI, e.g. the fronted host with Request.ServerName.
+	// If nil or if it returns an empty name, serverName is used, or host without SNI.
+	VerifyName func(host, serverName string) string
+
+	// RootCAs replaces the system roots used to verify the certificates of servers,
+	// e.g. to only trust an internal CA. See LoadCertPool.
+	RootCAs *x509.CertPool
+	// AdditionalRootCAs are trusted in addition to RootCAs or the system roots,
+	// e.g. the CA of a debugging proxy.
+	// When RootCAs or AdditionalRootCAs is set, the certificates of HTTPS proxies are verified too.
+	AdditionalRootCAs *x509.CertPool
+
+	// CertificateChecks enables OCSP, CRL and Certificate Transparency checks of
+	// the certificates of servers. Response.CertificateStatus holds their results.
+	CertificateChecks *CertificateChecks
+
+	// KeyLogWriter receives the TLS secrets of every connection (TCP, HTTPS proxies and QUIC)
+	// in NSS key log format, so that captured traffic can be decrypted with tools like Wireshark.
+	// If nil, the file named by the SSLKEYLOGFILE environment variable is used, if any.
+	// Using it compromises security and should only be done for debugging.
+	KeyLogWriter io.Writer
+
+	// ClientSessionCache stores the TLS sessions of TCP and QUIC connections so that
+	// new connections resume them with the pre_shared_key extension, like browsers.
+	// It is nil by default: every connection performs a full handshake, and servers
+	// cannot link the connections of the session. Set it to a TicketCache to opt in,
+	// its content is kept by Snapshot and can be persisted with its OnStore hook.
+	ClientSessionCache tls.ClientSessionCache
+
+	// FrameLogWriter receives every HTTP/2 frame and HTTP/3 frame event of the
+	// session's connections as JSON lines (see FrameRecord).
+	// It must be set before the first request is sent, connections opened
+	// before are not logged.
+	FrameLogWriter io.Writer
+
 	// If true, automatic decompression of response bodies is disabled.
 	// When disabled, compressed responses (gzip, deflate, brotli, zstd) are returned as-is.
 	DisableAutoDecompression bool
@@ -148,6 +278,25 @@ type Session struct {
 
 	logging       bool
 	loggingIgnore []*regexp.Regexp
+	logger        *slog.Logger
+	logConfig     *LogConfig
+
+	frameLogMu sync.Mutex
+
+	// fingerprints applied with ApplyJa3, ApplyHTTP2 and ApplyHTTP3, kept for Snapshot
+	ja3              *appliedJa3
+	http2Fingerprint string
+	http3Fingerprint string
+
+	// lastDocument is the final URL of the last ModeNavigate request, the
+	// initiator of the following requests
+	lastDocument *url.URL
+	// high-entropy client hints requested with Accept-CH, by origin
+	acceptCH map[string]map[string]bool
+	// ECHConfigList sent by servers rejecting ECH, by hostname
+	echRetryConfigs map[string][]byte
+	// CertificateStatus of the connections, by weak pointer to their leaf certificate
+	certificateStatuses sync.Map
 
 	ctx context.Context
 
@@ -192,12 +341,45 @@ type Request struct {
 	MaxRedirects uint
 	// If true, cookies won't be included in the request.
 	NoCookie bool
+	// Initiator is the URL of the page issuing the request, used by Session.CookiePolicy
+	// to tell same-site from cross-site requests. If empty, the request is same-site,
+	// like a URL typed in the address bar.
+	Initiator string
+	// Subresource is true if the request is not a top-level navigation (fetch, XHR,
+	// image, script...); the top-level site is then the one of Initiator.
+	Subresource bool
+	// Mode generates the headers of the session browser for this kind of request,
+	// see RequestMode. Initiator and Subresource are set from it if empty.
+	Mode RequestMode
+	// TypedNavigation marks a ModeNavigate request as typed in the address bar or
+	// opened from a bookmark: it has no initiator and Sec-Fetch-Site is "none".
+	// Other navigations are initiated by the last document of the session, if any.
+	TypedNavigation bool
+	// Sec-Fetch-Site of the previous requests of the redirect chain.
+	fetchSite string
+	// headers set by the user, before the headers of Mode are generated
+	userHeaders OrderedHeaders
+	// true once the request was sent again for Critical-CH
+	criticalCHRetried bool
 	// Maximum time to wait for request to complete.
 	TimeOut time.Duration
 	// Indicates if the current request is a result of a redirection.
 	IsRedirected bool
+	// Position of the request in its redirect chain, 0 for the initial request.
+	redirectIndex int
 	// If true, server's certificate is not verified.
 	InsecureSkipVerify bool
+	// ServerName is the SNI sent by the TLS and QUIC connections of the request
+	// instead of the host of Url, e.g. for domain fronting. It can be an IP.
+	// It applies to the connections opened by the request: idle connections to
+	// the host are reused regardless, so use another Session to mix server names.
+	ServerName string
+	// If true, the connections of the request send no SNI.
+	OmitServerName bool
+	// VerifyName is the name the certificate of the server is verified against.
+	// If empty, Session.VerifyName chooses it, defaulting to the SNI, or the host
+	// of Url without SNI.
+	VerifyName string
 
 	// If true, the body of the response is not read.
 	// The response body can be read from Response.RawBody and
@@ -252,6 +434,20 @@ type Response struct {
 
 	Session *Session
 
+	// ECHAccepted indicates if the server accepted Encrypted Client Hello,
+	// see Session.ECHConfigs.
+	ECHAccepted bool
+
+	// CertificateStatus is the result of Session.CertificateChecks for the
+	// connection of the response, nil if the checks are disabled.
+	CertificateStatus *CertificateStatus
+
+	// LocalAddr is the local address of the connection carrying the response,
+	// the one connected to the proxy when a proxy is used. For HTTP/3, it is the
+	// address the UDP socket is bound to: its IP is unspecified (0.0.0.0) unless
+	// it was chosen by Session.LocalAddrPool.
+	LocalAddr net.Addr
+
 	isHTTP3 bool
 }
 
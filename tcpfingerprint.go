@@ -0,0 +1,104 @@
+package azuretls
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// ErrTCPFingerprintUnsupported is returned by the dials of sessions shaping
+// their TCP fingerprint on other systems than Linux.
+var ErrTCPFingerprintUnsupported = errors.New("TCP fingerprint shaping is only supported on Linux")
+
+// TCPFingerprint are the socket options shaping the TCP SYN of connections, as
+// fingerprinted by tools like p0f. Zero values keep the defaults of the kernel.
+// The order of the TCP options, the window scale and the timestamps are chosen
+// by the kernel, so the SYN only gets as close to the OS as the kernel allows.
+type TCPFingerprint struct {
+	// TTL of the IP packets, or hop limit for IPv6, e.g. 128 for Windows and 64 for the others.
+	TTL int
+	// MSS is the maximum segment size announced in the SYN (TCP_MAXSEG).
+	MSS int
+	// WindowClamp bounds the announced receive window (TCP_WINDOW_CLAMP).
+	// The kernel derives the window scale from it, a small clamp disabling scaling.
+	WindowClamp int
+	// ReceiveBuffer is the size of the receive buffer (SO_RCVBUF), from which
+	// the kernel derives the initial window and the window scale.
+	ReceiveBuffer int
+	// NoDelay sets TCP_NODELAY: false enables Nagle's algorithm, which browsers
+	// disable. If nil, the default of the net package is kept, which disables it.
+	NoDelay *bool
+	// FastOpen sends the first data of connections in the SYN (TCP_FASTOPEN_CONNECT)
+	// to the servers the kernel has a Fast Open cookie of.
+	FastOpen bool
+}
+
+// PlatformTCPFingerprint returns the TCP fingerprint of the platform of a user
+// agent, as returned in sec-ch-ua-platform (e.g. "Windows", "macOS"), or nil
+// if the platform is unknown.
+func PlatformTCPFingerprint(platform string) *TCPFingerprint {
+	switch platform {
+	case "Windows":
+		return &TCPFingerprint{TTL: 128, MSS: 1460}
+	case "macOS", "iOS":
+		return &TCPFingerprint{TTL: 64, MSS: 1460}
+	case "Linux", "Android", "Chrome OS":
+		return &TCPFingerprint{TTL: 64}
+	}
+	return nil
+}
+
+// tcpFingerprint returns the TCP fingerprint of the connections of ctx, or nil.
+func (s *Session) tcpFingerprint(ctx context.Context) *TCPFingerprint {
+	if s.TCPFingerprint != nil {
+		return s.TCPFingerprint
+	}
+
+	if !s.ShapeTCPFingerprint {
+		return nil
+	}
+
+	userAgent := s.UserAgent
+	if ua, ok := ctx.Value(userAgentKey).(string); ok && ua != "" {
+		userAgent = ua
+	}
+
+	return PlatformTCPFingerprint(userAgentPlatform(userAgent))
+}
+
+// apply sets the socket options of fp before dialer connects.
+func (fp *TCPFingerprint) apply(dialer *net.Dialer) {
+	// ControlContext takes precedence over Control
+	if controlContext := dialer.ControlContext; controlContext != nil {
+		dialer.ControlContext = func(ctx context.Context, network, address string, c syscall.RawConn) error {
+			if err := controlContext(ctx, network, address, c); err != nil {
+				return err
+			}
+			return fp.control(network, address, c)
+		}
+		return
+	}
+
+	control := dialer.Control
+	dialer.Control = func(network, address string, c syscall.RawConn) error {
+		if control != nil {
+			if err := control(network, address, c); err != nil {
+				return err
+			}
+		}
+		return fp.control(network, address, c)
+	}
+}
+
+// applyConn sets the socket options of fp that the net package overrides
+// once connected.
+func (fp *TCPFingerprint) applyConn(conn net.Conn) {
+	if fp.NoDelay == nil {
+		return
+	}
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.SetNoDelay(*fp.NoDelay)
+	}
+}
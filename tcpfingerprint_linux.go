@@ -0,0 +1,61 @@
+//go:build linux
+
+package azuretls
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// control sets the socket options of fp on the socket of c before it connects.
+func (fp *TCPFingerprint) control(network, _ string, c syscall.RawConn) error {
+	var sockErr error
+
+	err := c.Control(func(fd uintptr) {
+		sockErr = fp.setsockopts(int(fd), network == "tcp6")
+	})
+	if err != nil {
+		return err
+	}
+
+	return sockErr
+}
+
+func (fp *TCPFingerprint) setsockopts(fd int, ipv6 bool) error {
+	if fp.TTL > 0 {
+		if ipv6 {
+			if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS, fp.TTL); err != nil {
+				return err
+			}
+		} else if err := unix.SetsockoptInt(fd, unix.IPPROTO_IP, unix.IP_TTL, fp.TTL); err != nil {
+			return err
+		}
+	}
+
+	if fp.MSS > 0 {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_MAXSEG, fp.MSS); err != nil {
+			return err
+		}
+	}
+
+	if fp.ReceiveBuffer > 0 {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, fp.ReceiveBuffer); err != nil {
+			return err
+		}
+	}
+
+	if fp.WindowClamp > 0 {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_WINDOW_CLAMP, fp.WindowClamp); err != nil {
+			return err
+		}
+	}
+
+	if fp.FastOpen {
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT, 1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package azuretls
+
+import "syscall"
+
+// control fails the dial, as the TCP fingerprint can only be shaped on Linux.
+func (fp *TCPFingerprint) control(_, _ string, _ syscall.RawConn) error {
+	return ErrTCPFingerprintUnsupported
+}
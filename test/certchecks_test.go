@@ -0,0 +1,298 @@
+package azuretls_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Noooste/azuretls-client"
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/ocsp"
+)
+
+var oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+type ctLog struct {
+	key *ecdsa.PrivateKey
+	der []byte
+}
+
+func newCTLog(t *testing.T) *ctLog {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &ctLog{key: key, der: der}
+}
+
+// sign returns an SCT (RFC 6962) of the log for a certificate (x509_entry) or
+// a precertificate (precert_entry, with the key hash of the issuer).
+func (l *ctLog) sign(t *testing.T, entry []byte, issuerKeyHash []byte) []byte {
+	t.Helper()
+
+	timestamp := uint64(time.Now().UnixMilli())
+
+	var signed cryptobyte.Builder
+	signed.AddUint8(0)
+	signed.AddUint8(0)
+	signed.AddUint64(timestamp)
+	if issuerKeyHash != nil {
+		signed.AddUint16(1)
+		signed.AddBytes(issuerKeyHash)
+	} else {
+		signed.AddUint16(0)
+	}
+	signed.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(entry) })
+	signed.AddUint16(0)
+
+	digest := sha256.Sum256(signed.BytesOrPanic())
+	sig, err := ecdsa.SignASN1(rand.Reader, l.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logID := sha256.Sum256(l.der)
+
+	var sct cryptobyte.Builder
+	sct.AddUint8(0)
+	sct.AddBytes(logID[:])
+	sct.AddUint64(timestamp)
+	sct.AddUint16(0)
+	sct.AddUint8(4)
+	sct.AddUint8(3)
+	sct.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(sig) })
+
+	return sct.BytesOrPanic()
+}
+
+type certChecksPKI struct {
+	ca, leaf *x509.Certificate
+	caKey    *ecdsa.PrivateKey
+	leafKey  *ecdsa.PrivateKey
+	logs     []*ctLog
+}
+
+// newCertChecksPKI returns a CA and a certificate for example.com with an
+// SCT of the second log embedded.
+func newCertChecksPKI(t *testing.T) *certChecksPKI {
+	t.Helper()
+
+	pki := &certChecksPKI{logs: []*ctLog{newCTLog(t), newCTLog(t)}}
+
+	var err error
+	if pki.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	if pki.leafKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &pki.caKey.PublicKey, pki.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pki.ca, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    caTemplate.NotBefore,
+		NotAfter:     caTemplate.NotAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	// the precertificate is the certificate without the SCT list
+	der, err = x509.CreateCertificate(rand.Reader, template, pki.ca, &pki.leafKey.PublicKey, pki.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	precert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuerKeyHash := sha256.Sum256(pki.ca.RawSubjectPublicKeyInfo)
+	sct := pki.logs[1].sign(t, precert.RawTBSCertificate, issuerKeyHash[:])
+
+	var list cryptobyte.Builder
+	list.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(sct) })
+	})
+
+	value, err := asn1.Marshal(list.BytesOrPanic())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template.ExtraExtensions = []pkix.Extension{{Id: oidSCTList, Value: value}}
+
+	der, err = x509.CreateCertificate(rand.Reader, template, pki.ca, &pki.leafKey.PublicKey, pki.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pki.leaf, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+
+	return pki
+}
+
+func (pki *certChecksPKI) ocspStaple(t *testing.T, status int) []byte {
+	t.Helper()
+
+	staple, err := ocsp.CreateResponse(pki.ca, pki.ca, ocsp.Response{
+		Status:       status,
+		SerialNumber: pki.leaf.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   time.Now().Add(time.Hour),
+		RevokedAt:    time.Now().Add(-time.Minute),
+	}, pki.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return staple
+}
+
+func TestCertificateChecks(t *testing.T) {
+	pki := newCertChecksPKI(t)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(pki.ca)
+
+	logs := []azuretls.CTLog{
+		{Description: "log 0", Key: pki.logs[0].der},
+		{Description: "log 1", Key: pki.logs[1].der},
+	}
+
+	get := func(staple []byte, checks *azuretls.CertificateChecks) (*azuretls.Response, error) {
+		t.Helper()
+
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		server.TLS = &tls.Config{
+			Certificates: []tls.Certificate{{
+				Certificate:                 [][]byte{pki.leaf.Raw, pki.ca.Raw},
+				PrivateKey:                  pki.leafKey,
+				OCSPStaple:                  staple,
+				SignedCertificateTimestamps: [][]byte{pki.logs[0].sign(t, pki.leaf.Raw, nil)},
+			}},
+		}
+		server.StartTLS()
+		defer server.Close()
+
+		session := azuretls.NewSession()
+		defer session.Close()
+
+		session.PinManager = azuretls.NewPinManager()
+		session.RootCAs = pool
+		session.CertificateChecks = checks
+		session.Dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+		}
+
+		// the checks run once per connection, the second response reuses it
+		if _, err := session.Get("https://example.com"); err != nil {
+			return nil, err
+		}
+		return session.Get("https://example.com")
+	}
+
+	var checked int
+
+	response, err := get(pki.ocspStaple(t, ocsp.Good), &azuretls.CertificateChecks{
+		OCSP:   true,
+		CTLogs: logs,
+		IsRevoked: func(cert, issuer *x509.Certificate) bool {
+			checked++
+			return false
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if checked != 1 {
+		t.Fatalf("expected the certificate to be checked once, got %d checks", checked)
+	}
+
+	status := response.CertificateStatus
+	if status == nil || !status.OCSPStapled || status.OCSPStatus != "good" {
+		t.Fatalf("expected a good OCSP staple, got %+v", status)
+	}
+
+	if len(status.SCTs) != 2 || status.SCTs[0].Source != "tls" || status.SCTs[0].Log != "log 0" ||
+		status.SCTs[1].Source != "certificate" || status.SCTs[1].Log != "log 1" {
+		t.Fatalf("expected an SCT in the handshake and one in the certificate, got %+v", status.SCTs)
+	}
+
+	var sctErr *azuretls.SCTError
+	if _, err = get(nil, &azuretls.CertificateChecks{CTLogs: logs, MinSCTs: 3}); !errors.As(err, &sctErr) || sctErr.Valid != 2 {
+		t.Fatalf("expected an SCTError, got %v", err)
+	}
+
+	var revoked *azuretls.RevokedCertificateError
+	if _, err = get(pki.ocspStaple(t, ocsp.Revoked), &azuretls.CertificateChecks{OCSP: true}); !errors.As(err, &revoked) || revoked.Source != "ocsp" {
+		t.Fatalf("expected a certificate revoked by OCSP, got %v", err)
+	}
+
+	if _, err = get(nil, &azuretls.CertificateChecks{RequireOCSPStaple: true}); !errors.Is(err, azuretls.ErrNoOCSPStaple) {
+		t.Fatalf("expected ErrNoOCSPStaple, got %v", err)
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: pki.leaf.SerialNumber, RevocationTime: time.Now().Add(-time.Minute)},
+		},
+	}, pki.ca, pki.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = get(nil, &azuretls.CertificateChecks{CRLs: []*x509.RevocationList{crl}}); !errors.As(err, &revoked) || revoked.Source != "crl" {
+		t.Fatalf("expected a certificate revoked by the CRL, got %v", err)
+	}
+}
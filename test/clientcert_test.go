@@ -0,0 +1,180 @@
+package azuretls_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Noooste/azuretls-client"
+	utls "github.com/Noooste/utls"
+)
+
+// newClientCertificate returns a client certificate for commonName signed by
+// parent, or self-signed if parent is nil, with its PEM encoded key.
+func newClientCertificate(t *testing.T, commonName string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestClientCertificates(t *testing.T) {
+	ca, caKey, _, _ := newClientCertificate(t, "ca", nil, nil)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	parse := func(commonName string) utls.Certificate {
+		_, _, certPEM, keyPEM := newClientCertificate(t, commonName, ca, caKey)
+
+		cert, err := azuretls.ParseClientCertificate(certPEM, keyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+
+	session := azuretls.NewSession()
+	defer session.Close()
+
+	session.InsecureSkipVerify = true
+
+	if _, err := session.Get(server.URL); err == nil {
+		t.Fatal("expected the server to require a client certificate")
+	}
+
+	session = azuretls.NewSession()
+	defer session.Close()
+
+	session.InsecureSkipVerify = true
+	session.ClientCertificates = []utls.Certificate{parse("default")}
+
+	response, err := session.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(response.Body) != "default" {
+		t.Fatalf("expected the default certificate, got %s", response.Body)
+	}
+
+	// the certificate is selected by host, falling back to ClientCertificates
+	perHost := parse("per-host")
+
+	session = azuretls.NewSession()
+	defer session.Close()
+
+	session.InsecureSkipVerify = true
+	session.ClientCertificates = []utls.Certificate{parse("default")}
+	session.GetClientCertificate = func(host string, info *utls.CertificateRequestInfo) (*utls.Certificate, error) {
+		if host == "127.0.0.1" {
+			return &perHost, nil
+		}
+		return nil, nil
+	}
+
+	response, err = session.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(response.Body) != "per-host" {
+		t.Fatalf("expected the certificate of the host, got %s", response.Body)
+	}
+}
+
+func TestPKCS12ClientCertificate(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	// client.p12 is exported with the defaults of OpenSSL 3 (AES-256-CBC, PBKDF2),
+	// client-legacy.p12 with -legacy (RC2, 3DES)
+	for _, file := range []string{"./testdata/client.p12", "./testdata/client-legacy.p12"} {
+		if _, err := azuretls.LoadPKCS12ClientCertificate(file, "wrong"); err == nil {
+			t.Fatalf("%s: expected an error for a wrong password", file)
+		}
+
+		cert, err := azuretls.LoadPKCS12ClientCertificate(file, "azuretls")
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+
+		if cert.Leaf.Subject.CommonName != "p12 client" || len(cert.Certificate) != 2 {
+			t.Fatalf("%s: expected the client certificate and its CA, got %s and %d certificates", file, cert.Leaf.Subject.CommonName, len(cert.Certificate))
+		}
+
+		session := azuretls.NewSession()
+		session.InsecureSkipVerify = true
+		session.ClientCertificates = []utls.Certificate{cert}
+
+		response, err := session.Get(server.URL)
+		session.Close()
+
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+
+		if string(response.Body) != "p12 client" {
+			t.Fatalf("%s: expected the certificate of the archive, got %s", file, response.Body)
+		}
+	}
+}
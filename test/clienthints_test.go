@@ -0,0 +1,107 @@
+package azuretls_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Noooste/azuretls-client"
+)
+
+func TestNewClientHints(t *testing.T) {
+	for ua, expected := range map[string]string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/135.0.0.0 Safari/537.36":               `"Google Chrome";v="135", "Not-A.Brand";v="8", "Chromium";v="135"`,
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/133.0.0.0 Safari/537.36":               `"Not(A:Brand";v="99", "Google Chrome";v="133", "Chromium";v="133"`,
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/135.0.0.0 Safari/537.36 Edg/135.0.0.0": `"Microsoft Edge";v="135", "Not-A.Brand";v="8", "Chromium";v="135"`,
+	} {
+		ch := azuretls.NewClientHints(ua)
+		if ch == nil {
+			t.Fatalf("expected client hints for %s", ua)
+		}
+
+		if v := ch.Header(azuretls.HintUA); v != expected {
+			t.Fatalf("expected sec-ch-ua %s, got %s", expected, v)
+		}
+
+		if v := ch.Header(azuretls.HintPlatform); v != `"Windows"` {
+			t.Fatalf("expected Windows platform, got %s", v)
+		}
+	}
+
+	android := azuretls.NewClientHints("Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/135.0.0.0 Mobile Safari/537.36")
+	if android.Header(azuretls.HintMobile) != "?1" || android.Header(azuretls.HintPlatform) != `"Android"` {
+		t.Fatal("expected mobile Android client hints")
+	}
+
+	if azuretls.NewClientHints("Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:136.0) Gecko/20100101 Firefox/136.0") != nil {
+		t.Fatal("expected no client hints for Firefox")
+	}
+}
+
+type retryObserver struct {
+	azuretls.NopObserver
+	retries atomic.Int32
+}
+
+func (o *retryObserver) Retried(_ *azuretls.Request, reason string) {
+	if reason == "critical-ch" {
+		o.retries.Add(1)
+	}
+}
+
+func TestCriticalClientHints(t *testing.T) {
+	var requests atomic.Int32
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Accept-CH", "Sec-CH-UA-Platform-Version, Sec-CH-UA-Full-Version-List, Device-Memory")
+		w.Header().Set("Critical-CH", "Sec-CH-UA-Platform-Version")
+		_ = json.NewEncoder(w).Encode(r.Header)
+	}))
+	defer server.Close()
+
+	observer := &retryObserver{}
+
+	session := azuretls.NewSession()
+	defer session.Close()
+
+	session.InsecureSkipVerify = true
+	session.Observer = observer
+
+	response, err := session.Get(server.URL, azuretls.ModeNavigate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var received http.Header
+	if err = json.Unmarshal(response.Body, &received); err != nil {
+		t.Fatal(err)
+	}
+
+	if requests.Load() != 2 || observer.retries.Load() != 1 {
+		t.Fatalf("expected one critical-ch retry, got %d requests and %d retries", requests.Load(), observer.retries.Load())
+	}
+
+	if v := received.Get("Sec-CH-UA-Platform-Version"); v != `"19.0.0"` {
+		t.Fatalf("expected sec-ch-ua-platform-version on retry, got %q", v)
+	}
+
+	if v := received.Get("Sec-CH-UA-Full-Version-List"); v != `"Google Chrome";v="135.0.7049.115", "Not-A.Brand";v="8.0.0.0", "Chromium";v="135.0.7049.115"` {
+		t.Fatalf("unexpected sec-ch-ua-full-version-list %q", v)
+	}
+
+	if v := received.Get("Sec-CH-UA-Arch"); v != "" {
+		t.Fatalf("expected no hint which was not requested, got sec-ch-ua-arch %q", v)
+	}
+
+	// the hints are sent from the first request once accepted
+	if _, err = session.Get(server.URL, azuretls.ModeNavigate); err != nil {
+		t.Fatal(err)
+	}
+
+	if requests.Load() != 3 {
+		t.Fatalf("expected no retry once hints are accepted, got %d requests", requests.Load())
+	}
+}
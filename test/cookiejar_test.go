@@ -0,0 +1,174 @@
+package azuretls_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Noooste/azuretls-client"
+)
+
+func newTestJar(t *testing.T) *azuretls.CookieJar {
+	jar := azuretls.NewCookieJar()
+
+	u, _ := url.Parse("https://www.example.com/")
+	jar.SetCookies(u, parseSetCookies(
+		"sid=abc; Secure; HttpOnly; SameSite=Strict",
+		"pref=dark; Domain=example.com; Path=/settings; Max-Age=3600",
+	))
+
+	if len(jar.All()) != 2 {
+		t.Fatal("expected 2 cookies in the jar")
+	}
+
+	return jar
+}
+
+func TestCookieJarFormats(t *testing.T) {
+	for _, format := range []azuretls.CookieFormat{
+		azuretls.CookieFormatNetscape,
+		azuretls.CookieFormatJSON,
+		azuretls.CookieFormatBrowser,
+	} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := newTestJar(t).Export(&buf, format); err != nil {
+				t.Fatal(err)
+			}
+
+			jar := azuretls.NewCookieJar()
+			n, err := jar.Import(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			all := jar.All()
+			if n != 2 || len(all) != 2 {
+				t.Fatalf("expected 2 cookies, got %d", len(all))
+			}
+
+			pref, sid := all[0], all[1]
+			if pref.Name != "pref" || pref.HostOnly || pref.Path != "/settings" || pref.Expires.IsZero() {
+				t.Fatalf("unexpected domain cookie %+v", pref)
+			}
+
+			if sid.Name != "sid" || !sid.HostOnly || !sid.HttpOnly || !sid.Secure || !sid.Expires.IsZero() {
+				t.Fatalf("unexpected host cookie %+v", sid)
+			}
+
+			u, _ := url.Parse("https://www.example.com/settings/theme")
+			if cookies := jar.Cookies(u); len(cookies) != 2 {
+				t.Fatalf("expected both cookies to be sent, got %v", cookies)
+			}
+		})
+	}
+}
+
+func TestCookieJarImportNetscape(t *testing.T) {
+	expires := time.Now().Add(time.Hour).Unix()
+	file := "# Netscape HTTP Cookie File\n" +
+		"# comment\n" +
+		"\n" +
+		".example.org\tTRUE\t/\tFALSE\t" + strconv.FormatInt(expires, 10) + "\ta\t1\n" +
+		"#HttpOnly_example.org\tFALSE\t/\tTRUE\t0\tb\t2\n" +
+		"example.org\tFALSE\t/\tFALSE\t1\texpired\t3\n"
+
+	jar := azuretls.NewCookieJar()
+	n, err := jar.Import(strings.NewReader(file), azuretls.CookieFormatNetscape)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 3 || len(jar.All()) != 2 {
+		t.Fatalf("expected 3 cookies read and 2 stored, got %d and %d", n, len(jar.All()))
+	}
+
+	if _, err = jar.Import(strings.NewReader("example.org\tFALSE\t/\n"), azuretls.CookieFormatNetscape); err == nil {
+		t.Fatal("expected malformed line error")
+	}
+}
+
+func TestCookieJarImportDevTools(t *testing.T) {
+	dump := `[
+		{"name":"a","value":"1","domain":".example.net","path":"/","expires":-1,"httpOnly":false,"secure":true,"session":true,"sameSite":"None"},
+		{"name":"b","value":"2","domain":"www.example.net","path":"/","expires":4102444800.5,"httpOnly":true,"secure":false,"session":false,"sameSite":"Lax"}
+	]`
+
+	jar := azuretls.NewCookieJar()
+	if _, err := jar.Import(strings.NewReader(dump), azuretls.CookieFormatBrowser); err != nil {
+		t.Fatal(err)
+	}
+
+	all := jar.All()
+	if len(all) != 2 {
+		t.Fatalf("expected 2 cookies, got %d", len(all))
+	}
+
+	if all[0].HostOnly || !all[0].Expires.IsZero() || all[0].SameSite != "None" {
+		t.Fatalf("unexpected session cookie %+v", all[0])
+	}
+
+	if !all[1].HostOnly || all[1].Expires.Year() != 2100 || all[1].SameSite != "Lax" {
+		t.Fatalf("unexpected persistent cookie %+v", all[1])
+	}
+
+	if _, err := jar.Import(strings.NewReader(dump), "unknown"); err == nil {
+		t.Fatal("expected unknown format error")
+	}
+}
+
+func TestSessionCookies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "token", Value: "1"})
+			http.SetCookie(w, &http.Cookie{Name: "tracker", Value: "x"})
+		case "/refresh":
+			http.SetCookie(w, &http.Cookie{Name: "token", Value: "2"})
+			http.SetCookie(w, &http.Cookie{Name: "tracker", Value: "x"})
+		case "/logout":
+			http.SetCookie(w, &http.Cookie{Name: "token", MaxAge: -1})
+		}
+	}))
+	defer server.Close()
+
+	session := azuretls.NewSession()
+	defer session.Close()
+
+	var changes []string
+	session.OnCookieChange = func(response *azuretls.Response, cookie *azuretls.JarCookie, change azuretls.CookieChange) {
+		changes = append(changes, cookie.Name+"="+cookie.Value+" "+change.String())
+	}
+
+	for _, path := range []string{"/login", "/refresh", "/logout"} {
+		if _, err := session.Get(server.URL + path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{"token=1 added", "tracker=x added", "token=2 updated", "token=2 expired"}
+	if strings.Join(changes, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected changes %v, got %v", expected, changes)
+	}
+
+	if err := session.SetCookie(&azuretls.JarCookie{Name: "manual", Value: "1", Domain: "127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if all := session.AllCookies(); len(all) != 2 {
+		t.Fatalf("expected 2 cookies, got %d", len(all))
+	}
+
+	if !session.DeleteCookie("tracker", "127.0.0.1", "/") || session.DeleteCookie("tracker", "127.0.0.1", "/") {
+		t.Fatal("expected tracker to be deleted once")
+	}
+
+	if n := session.ClearCookies("127.0.0.1"); n != 1 || len(session.AllCookies()) != 0 {
+		t.Fatalf("expected the last cookie to be cleared, got %d", n)
+	}
+}
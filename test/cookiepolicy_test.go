@@ -0,0 +1,181 @@
+package azuretls_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Noooste/azuretls-client"
+)
+
+func TestCookiePolicy(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/set":
+			for _, c := range []string{
+				"strict=1; SameSite=Strict",
+				"lax=1; SameSite=Lax",
+				"default=1",
+				"none=1; SameSite=None; Secure",
+				"insecure=1; SameSite=None",
+				"deep=1; Path=/check; SameSite=None; Secure",
+				"__Host-ok=1; Secure; Path=/; SameSite=None",
+				"__Host-bad=1; Secure; Path=/set; SameSite=None",
+				"__Secure-bad=1; SameSite=None",
+			} {
+				w.Header().Add("Set-Cookie", c)
+			}
+		case "/partitioned":
+			w.Header().Add("Set-Cookie", "chip=1; Secure; Path=/; SameSite=None; Partitioned")
+		case "/check":
+			_, _ = w.Write([]byte(r.Header.Get("Cookie")))
+		}
+	}))
+	defer server.Close()
+
+	session := azuretls.NewSession()
+	defer session.Close()
+
+	session.InsecureSkipVerify = true
+	session.CookiePolicy = azuretls.BrowserCookiePolicy()
+
+	if _, err := session.Get(server.URL + "/set"); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(request *azuretls.Request, expected string) {
+		t.Helper()
+
+		request.Method = http.MethodGet
+		request.Url = server.URL + "/check"
+
+		response, err := session.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(response.Body) != expected {
+			t.Fatalf("expected Cookie %q, got %q", expected, response.Body)
+		}
+	}
+
+	// same-site: every cookie, the longest path first
+	check(&azuretls.Request{}, "deep=1; strict=1; lax=1; default=1; none=1; __Host-ok=1")
+
+	// cross-site navigation: no Strict cookie
+	check(&azuretls.Request{Initiator: "https://other.example"}, "deep=1; lax=1; default=1; none=1; __Host-ok=1")
+
+	// cross-site subresource: only SameSite=None cookies
+	check(&azuretls.Request{Initiator: "https://other.example", Subresource: true}, "deep=1; none=1; __Host-ok=1")
+
+	// partitioned cookie set in an iframe of top.example
+	if _, err := session.Do(&azuretls.Request{
+		Method:      http.MethodGet,
+		Url:         server.URL + "/partitioned",
+		Initiator:   "https://top.example",
+		Subresource: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	check(&azuretls.Request{Initiator: "https://top.example", Subresource: true}, "deep=1; none=1; __Host-ok=1; chip=1")
+	check(&azuretls.Request{Initiator: "https://other.example", Subresource: true}, "deep=1; none=1; __Host-ok=1")
+
+	var partitioned *azuretls.JarCookie
+	for _, c := range session.AllCookies() {
+		if c.Name == "chip" {
+			partitioned = c
+		}
+	}
+
+	if partitioned == nil || partitioned.PartitionKey != "https://top.example" {
+		t.Fatalf("expected chip to be partitioned, got %+v", partitioned)
+	}
+}
+
+func TestCookiePolicyStoreCrossSitePost(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Set-Cookie", "strict=1; SameSite=Strict")
+		w.Header().Add("Set-Cookie", "lax=1")
+	}))
+	defer server.Close()
+
+	session := azuretls.NewSession()
+	defer session.Close()
+
+	session.InsecureSkipVerify = true
+	session.CookiePolicy = azuretls.BrowserCookiePolicy()
+
+	// cross-site subresource POST: Lax and Strict cookies are rejected
+	if _, err := session.Do(&azuretls.Request{
+		Method:      http.MethodPost,
+		Url:         server.URL,
+		Initiator:   "https://other.example",
+		Subresource: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(session.AllCookies()); n != 0 {
+		t.Fatalf("expected no cookie, got %d", n)
+	}
+
+	// cross-site top-level POST navigation, e.g. a form submission: they are stored
+	if _, err := session.Do(&azuretls.Request{
+		Method:    http.MethodPost,
+		Url:       server.URL,
+		Initiator: "https://other.example",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(session.AllCookies()); n != 2 {
+		t.Fatalf("expected 2 cookies, got %d", n)
+	}
+}
+
+func TestCookiePolicyWebsocket(t *testing.T) {
+	var cookie string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/set" {
+			w.Header().Add("Set-Cookie", "strict=1; SameSite=Strict")
+			w.Header().Add("Set-Cookie", "plain=1")
+			return
+		}
+
+		cookie = r.Header.Get("Cookie")
+		w.Header().Add("Set-Cookie", "lax=1; SameSite=Lax")
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	session := azuretls.NewSession()
+	defer session.Close()
+
+	session.CookiePolicy = &azuretls.CookiePolicy{SameSite: true}
+
+	if _, err := session.Get(server.URL + "/set"); err != nil {
+		t.Fatal(err)
+	}
+
+	// cross-site websocket: the handshake is a subresource request
+	_, err := session.NewWebsocket(strings.Replace(server.URL, "http://", "ws://", 1)+"/ws", 0, 0,
+		azuretls.ModeWebsocket,
+		azuretls.OrderedHeaders{{"Referer", "https://other.example/"}},
+	)
+	if err == nil {
+		t.Fatal("expected a bad handshake")
+	}
+
+	if cookie != "plain=1" {
+		t.Fatalf("expected Cookie %q, got %q", "plain=1", cookie)
+	}
+
+	for _, c := range session.AllCookies() {
+		if c.Name == "lax" {
+			t.Fatal("expected lax cookie of a cross-site subresource to be rejected")
+		}
+	}
+}
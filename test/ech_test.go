@@ -0,0 +1,186 @@
+package azuretls_test
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/Noooste/azuretls-client"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// newECHConfig returns an ECHConfig (draft-ietf-tls-esni-22) using
+// DHKEM(X25519, HKDF-SHA256), HKDF-SHA256 and AES-128-GCM, and its private key.
+func newECHConfig(t *testing.T, id uint8, publicName string) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey := key.PublicKey().Bytes()
+
+	contents := []byte{id, 0x00, 0x20}
+	contents = binary.BigEndian.AppendUint16(contents, uint16(len(publicKey)))
+	contents = append(contents, publicKey...)
+	contents = append(contents, 0x00, 0x04, 0x00, 0x01, 0x00, 0x01)
+	contents = append(contents, 0, byte(len(publicName)))
+	contents = append(contents, publicName...)
+	contents = append(contents, 0x00, 0x00)
+
+	config := []byte{0xfe, 0x0d}
+	config = binary.BigEndian.AppendUint16(config, uint16(len(contents)))
+	config = append(config, contents...)
+
+	return config, key.Bytes()
+}
+
+func echConfigList(config []byte) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(config))), config...)
+}
+
+type staticECHResolver map[string][]byte
+
+func (r staticECHResolver) LookupECHConfigList(_ context.Context, host string) ([]byte, error) {
+	return r[host], nil
+}
+
+func TestEncryptedClientHello(t *testing.T) {
+	config, privateKey := newECHConfig(t, 1, "public.test")
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strconv.FormatBool(r.TLS.ECHAccepted) + " " + r.TLS.ServerName))
+	}))
+	server.TLS = &tls.Config{
+		MinVersion: tls.VersionTLS13,
+		EncryptedClientHelloKeys: []tls.EncryptedClientHelloKey{
+			{Config: config, PrivateKey: privateKey, SendAsRetry: true},
+		},
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	newSession := func() *azuretls.Session {
+		session := azuretls.NewSession()
+		session.InsecureSkipVerify = true
+		session.Dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+		}
+		return session
+	}
+
+	get := func(session *azuretls.Session, expected string) {
+		t.Helper()
+
+		response, err := session.Get("https://ech.test/")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(response.Body) != expected {
+			t.Fatalf("expected %q, got %q", expected, response.Body)
+		}
+
+		if response.ECHAccepted != (expected == "true ech.test") {
+			t.Fatalf("expected ECHAccepted to be reported for %q", expected)
+		}
+	}
+
+	session := newSession()
+	defer session.Close()
+
+	get(session, "false ech.test")
+
+	// the inner ClientHello carries the real server name
+	session = newSession()
+	defer session.Close()
+
+	session.ECHConfigs = map[string][]byte{"ech.test": echConfigList(config)}
+	get(session, "true ech.test")
+
+	// a stale config is rejected, the connection is retried with the server configs
+	stale, _ := newECHConfig(t, 2, "public.test")
+
+	session = newSession()
+	defer session.Close()
+
+	session.ECHResolver = staticECHResolver{"ech.test": echConfigList(stale)}
+	get(session, "true ech.test")
+}
+
+func TestDoHECHResolver(t *testing.T) {
+	config, _ := newECHConfig(t, 1, "public.test")
+	configList := echConfigList(config)
+
+	var queries int
+	var userAgent string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		userAgent = r.UserAgent()
+
+		var query dnsmessage.Message
+		body := make([]byte, r.ContentLength)
+		_, _ = r.Body.Read(body)
+		if err := query.Unpack(body); err != nil || len(query.Questions) != 1 || query.Questions[0].Type != dnsmessage.TypeHTTPS {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		question := query.Questions[0]
+		builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: query.ID, Response: true})
+		_ = builder.StartQuestions()
+		_ = builder.Question(question)
+		_ = builder.StartAnswers()
+
+		if question.Name.String() == "ech.test." {
+			record := dnsmessage.HTTPSResource{SVCBResource: dnsmessage.SVCBResource{Priority: 1, Target: question.Name}}
+			record.SetParam(dnsmessage.SVCParamECH, configList)
+
+			_ = builder.HTTPSResource(dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 60}, record)
+		}
+
+		msg, _ := builder.Finish()
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(msg)
+	}))
+	defer server.Close()
+
+	resolver := azuretls.NewDoHECHResolver(server.URL)
+	defer resolver.Session.Close()
+
+	for i := 0; i < 2; i++ {
+		list, err := resolver.LookupECHConfigList(context.Background(), "ech.test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(list) != string(configList) {
+			t.Fatalf("expected the ECHConfigList of the HTTPS record, got %x", list)
+		}
+	}
+
+	if queries != 1 {
+		t.Fatalf("expected the answer to be cached, got %d queries", queries)
+	}
+
+	// the queries are sent by the session of the resolver
+	if userAgent != resolver.Session.UserAgent {
+		t.Fatalf("expected the user agent of the session, got %s", userAgent)
+	}
+
+	list, err := resolver.LookupECHConfigList(context.Background(), "no-ech.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list != nil {
+		t.Fatalf("expected no ECHConfigList, got %x", list)
+	}
+}
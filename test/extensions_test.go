@@ -0,0 +1,105 @@
+package azuretls_test
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/Noooste/azuretls-client"
+)
+
+const shuffleJa3 = "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53,65281-27-0-51-65037-23-5-35-11-13-10-16-18-43-45-17613-21,29-23-24,0"
+
+func isGreaseValue(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func clientHelloExtensions(t *testing.T, browser string, shuffle bool, n int) [][]uint16 {
+	t.Helper()
+
+	var (
+		mu     sync.Mutex
+		orders [][]uint16
+	)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			var exts []uint16
+			for _, ext := range hello.Extensions {
+				if !isGreaseValue(ext) {
+					exts = append(exts, ext)
+				}
+			}
+
+			mu.Lock()
+			orders = append(orders, exts)
+			mu.Unlock()
+			return nil, nil
+		},
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	for i := 0; i < n; i++ {
+		session := azuretls.NewSession()
+		session.InsecureSkipVerify = true
+		session.Browser = browser
+		session.ShuffleExtensions = shuffle
+
+		// ApplyJa3 reproduces the extension order of the fingerprint
+		if err := session.ApplyJa3(shuffleJa3, browser); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := session.Get(server.URL); err != nil {
+			t.Fatal(err)
+		}
+
+		session.Close()
+	}
+
+	return orders
+}
+
+func TestShuffleExtensions(t *testing.T) {
+	fixed := clientHelloExtensions(t, azuretls.Chrome, false, 2)
+	if len(fixed) != 2 || !slices.Equal(fixed[0], fixed[1]) {
+		t.Fatalf("expected the same extension order without shuffling, got %v", fixed)
+	}
+
+	shuffled := clientHelloExtensions(t, azuretls.Chrome, true, 8)
+
+	distinct := make(map[string]bool)
+	for _, order := range shuffled {
+		distinct[fmt.Sprint(order)] = true
+
+		// padding stays last and the set of extensions is unchanged (JA4)
+		if last := fixed[0][len(fixed[0])-1]; last == 21 && order[len(order)-1] != last {
+			t.Fatalf("expected padding last, got %v", order)
+		}
+
+		sorted, expected := slices.Sorted(slices.Values(order)), slices.Sorted(slices.Values(fixed[0]))
+		if !slices.Equal(sorted, expected) {
+			t.Fatalf("expected extensions %v, got %v", expected, sorted)
+		}
+	}
+
+	if len(distinct) < 2 {
+		t.Fatalf("expected extension orders to differ, got %v", shuffled)
+	}
+}
+
+func TestShuffleExtensionsFirefox(t *testing.T) {
+	orders := clientHelloExtensions(t, azuretls.Firefox, true, 4)
+
+	for _, order := range orders[1:] {
+		if !slices.Equal(order, orders[0]) {
+			t.Fatalf("expected Firefox extensions not to be shuffled, got %v", orders)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package azuretls_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Noooste/azuretls-client"
+	fhttp "github.com/Noooste/fhttp"
+)
+
+func newHeaderEchoServer() *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/page", http.StatusFound)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(r.Header)
+	}))
+}
+
+func sentHeaderOrder(response *azuretls.Response) string {
+	var order []string
+	for _, key := range response.Request.HttpRequest.Header[fhttp.HeaderOrderKey] {
+		if response.Request.HttpRequest.Header.Get(key) != "" {
+			order = append(order, key)
+		}
+	}
+	return strings.Join(order, ",")
+}
+
+func TestRequestMode(t *testing.T) {
+	server := newHeaderEchoServer()
+	defer server.Close()
+
+	session := azuretls.NewSession()
+	defer session.Close()
+
+	session.InsecureSkipVerify = true
+
+	send := func(request *azuretls.Request) (*azuretls.Response, http.Header) {
+		t.Helper()
+
+		response, err := session.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var received http.Header
+		if err = json.Unmarshal(response.Body, &received); err != nil {
+			t.Fatal(err)
+		}
+
+		return response, received
+	}
+
+	response, received := send(&azuretls.Request{
+		Method: http.MethodGet,
+		Url:    server.URL + "/page",
+		Mode:   azuretls.ModeNavigate,
+	})
+
+	expectedOrder := "sec-ch-ua,sec-ch-ua-mobile,sec-ch-ua-platform,upgrade-insecure-requests,user-agent,accept," +
+		"sec-fetch-site,sec-fetch-mode,sec-fetch-user,sec-fetch-dest,accept-encoding,accept-language,priority"
+	if order := sentHeaderOrder(response); order != expectedOrder {
+		t.Fatalf("expected header order %s, got %s", expectedOrder, order)
+	}
+
+	for key, expected := range map[string]string{
+		"Sec-Ch-Ua":       `"Google Chrome";v="135", "Not-A.Brand";v="8", "Chromium";v="135"`,
+		"Sec-Fetch-Site":  "none",
+		"Sec-Fetch-Mode":  "navigate",
+		"Sec-Fetch-Dest":  "document",
+		"Accept-Language": "en-US,en;q=0.9",
+	} {
+		if v := received.Get(key); v != expected {
+			t.Fatalf("expected %s %q, got %q", key, expected, v)
+		}
+	}
+
+	// subresources are initiated by the last document
+	_, received = send(&azuretls.Request{
+		Method: http.MethodGet,
+		Url:    server.URL + "/api",
+		Mode:   azuretls.ModeFetch,
+	})
+
+	if v := received.Get("Sec-Fetch-Site"); v != "same-origin" {
+		t.Fatalf("expected same-origin fetch, got %q", v)
+	}
+
+	if v := received.Get("Origin"); v != "" {
+		t.Fatalf("expected no Origin on a same-origin GET, got %q", v)
+	}
+
+	if v := received.Get("Referer"); v != server.URL+"/page" {
+		t.Fatalf("expected Referer %s/page, got %q", server.URL, v)
+	}
+
+	_, received = send(&azuretls.Request{
+		Method:    http.MethodPost,
+		Url:       server.URL + "/api",
+		Mode:      azuretls.ModeFetch,
+		Initiator: "https://www.example.com/shop?id=1",
+		Body:      "{}",
+		OrderedHeaders: azuretls.OrderedHeaders{
+			{"content-type", "application/json"},
+			{"x-custom", "1"},
+		},
+	})
+
+	for key, expected := range map[string]string{
+		"Sec-Fetch-Site": "cross-site",
+		"Sec-Fetch-Mode": "cors",
+		"Origin":         "https://www.example.com",
+		"Referer":        "https://www.example.com/",
+		"Content-Type":   "application/json",
+		"X-Custom":       "1",
+	} {
+		if v := received.Get(key); v != expected {
+			t.Fatalf("expected %s %q, got %q", key, expected, v)
+		}
+	}
+
+	// the site relation is kept along redirects
+	response, received = send(&azuretls.Request{
+		Method:    http.MethodGet,
+		Url:       server.URL + "/redirect",
+		Mode:      azuretls.ModeNavigate,
+		Initiator: "https://www.example.com/",
+	})
+
+	if v := received.Get("Sec-Fetch-Site"); v != "cross-site" {
+		t.Fatalf("expected cross-site navigation after redirect, got %q", v)
+	}
+
+	if !strings.HasSuffix(response.Url, "/page") {
+		t.Fatalf("expected redirect to be followed, got %s", response.Url)
+	}
+}
+
+func TestRequestModeFirefox(t *testing.T) {
+	server := newHeaderEchoServer()
+	defer server.Close()
+
+	session := azuretls.NewSession()
+	defer session.Close()
+
+	session.InsecureSkipVerify = true
+	session.Browser = azuretls.Firefox
+	session.UserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:136.0) Gecko/20100101 Firefox/136.0"
+
+	response, err := session.Get(server.URL+"/image.png", azuretls.ModeImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedOrder := "user-agent,accept,accept-language,accept-encoding,sec-fetch-dest,sec-fetch-mode,sec-fetch-site,priority"
+	if order := sentHeaderOrder(response); order != expectedOrder {
+		t.Fatalf("expected header order %s, got %s", expectedOrder, order)
+	}
+
+	var received http.Header
+	if err = json.Unmarshal(response.Body, &received); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := received.Get("Sec-Ch-Ua"); v != "" {
+		t.Fatalf("expected no client hints for Firefox, got %q", v)
+	}
+
+	if v := received.Get("Sec-Fetch-Dest"); v != "image" {
+		t.Fatalf("expected image destination, got %q", v)
+	}
+}
+
+func TestRequestModeNavigationChain(t *testing.T) {
+	server := newHeaderEchoServer()
+	defer server.Close()
+
+	session := azuretls.NewSession()
+	defer session.Close()
+
+	session.InsecureSkipVerify = true
+
+	navigate := func(path string, typed bool) http.Header {
+		t.Helper()
+
+		response, err := session.Do(&azuretls.Request{
+			Method:          http.MethodGet,
+			Url:             server.URL + path,
+			Mode:            azuretls.ModeNavigate,
+			TypedNavigation: typed,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var received http.Header
+		if err = json.Unmarshal(response.Body, &received); err != nil {
+			t.Fatal(err)
+		}
+
+		return received
+	}
+
+	// the first navigation has no initiator
+	if v := navigate("/page", false).Get("Sec-Fetch-Site"); v != "none" {
+		t.Fatalf("expected first navigation to be none, got %q", v)
+	}
+
+	// a link followed from the last document
+	received := navigate("/next", false)
+	if v := received.Get("Sec-Fetch-Site"); v != "same-origin" {
+		t.Fatalf("expected same-origin follow-up navigation, got %q", v)
+	}
+
+	if v := received.Get("Referer"); v != server.URL+"/page" {
+		t.Fatalf("expected Referer %s/page, got %q", server.URL, v)
+	}
+
+	// a URL typed in the address bar
+	received = navigate("/typed", true)
+	if v := received.Get("Sec-Fetch-Site"); v != "none" {
+		t.Fatalf("expected typed navigation to be none, got %q", v)
+	}
+
+	if v := received.Get("Referer"); v != "" {
+		t.Fatalf("expected no Referer on a typed navigation, got %q", v)
+	}
+}
@@ -0,0 +1,88 @@
+package azuretls_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Noooste/azuretls-client"
+)
+
+const firefoxUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:136.0) Gecko/20100101 Firefox/136.0"
+
+func hasIssue(issues []azuretls.FingerprintIssue, layer string) bool {
+	for _, issue := range issues {
+		if issue.Layer == layer {
+			return true
+		}
+	}
+	return false
+}
+
+func TestValidateFingerprint(t *testing.T) {
+	for _, browser := range []string{azuretls.Chrome, azuretls.Firefox, azuretls.Safari} {
+		session := azuretls.NewSession()
+		session.Browser = browser
+
+		switch browser {
+		case azuretls.Firefox:
+			session.UserAgent = firefoxUserAgent
+		case azuretls.Safari:
+			session.UserAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/18.3 Safari/605.1.15"
+		}
+
+		if issues := session.ValidateFingerprint(); len(issues) > 0 {
+			t.Fatalf("expected no issue for %s, got %v", browser, issues)
+		}
+
+		session.Close()
+	}
+
+	session := azuretls.NewSession()
+	defer session.Close()
+
+	session.UserAgent = firefoxUserAgent
+	if issues := session.ValidateFingerprint(); !hasIssue(issues, azuretls.LayerUserAgent) {
+		t.Fatalf("expected a user agent issue, got %v", issues)
+	}
+
+	session.UserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	if issues := session.ValidateFingerprint(); !hasIssue(issues, azuretls.LayerTLS) {
+		t.Fatalf("expected a TLS issue for the ALPS codepoint of Chrome 120, got %v", issues)
+	}
+
+	session.UserAgent = ""
+	if err := session.ApplyHTTP2("1:65536,2:0,4:131072,5:16384|12517377|0|m,p,a,s"); err != nil {
+		t.Fatal(err)
+	}
+
+	issues := session.ValidateFingerprint()
+	if len(issues) != 2 || !hasIssue(issues, azuretls.LayerHTTP2) {
+		t.Fatalf("expected SETTINGS and pseudo-header issues, got %v", issues)
+	}
+
+	session = azuretls.NewSession()
+	defer session.Close()
+
+	session.Browser = azuretls.Firefox
+	session.UserAgent = firefoxUserAgent
+	session.OrderedHeaders = azuretls.OrderedHeaders{
+		{"sec-ch-ua", `"Google Chrome";v="135", "Not-A.Brand";v="8", "Chromium";v="135"`},
+	}
+
+	if issues := session.ValidateFingerprint(); !hasIssue(issues, azuretls.LayerHeaders) {
+		t.Fatalf("expected a header issue, got %v", issues)
+	}
+}
+
+func TestStrictFingerprint(t *testing.T) {
+	session := azuretls.NewSession()
+	defer session.Close()
+
+	session.StrictFingerprint = true
+	session.UserAgent = firefoxUserAgent
+
+	_, err := session.Get("https://example.com")
+	if !errors.Is(err, azuretls.ErrInconsistentFingerprint) {
+		t.Fatalf("expected ErrInconsistentFingerprint, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package azuretls_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Noooste/azuretls-client"
+)
+
+func TestFrameLogHTTP2(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	var buf bytes.Buffer
+
+	session := azuretls.NewSession()
+	defer session.Close()
+
+	session.InsecureSkipVerify = true
+	session.FrameLogWriter = &buf
+
+	response, err := session.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.HttpResponse.ProtoMajor != 2 {
+		t.Fatalf("expected HTTP/2, got %s", response.HttpResponse.Proto)
+	}
+
+	var (
+		sentSettings, recvSettings bool
+		requestHeaders             [][2]string
+		data                       bool
+	)
+
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record azuretls.FrameRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+
+		if record.Proto != azuretls.ProtoHTTP2 || record.Error != "" {
+			t.Fatalf("unexpected record %s", line)
+		}
+
+		switch {
+		case record.Type == "SETTINGS" && !record.Ack && record.Direction == azuretls.FrameSent:
+			sentSettings = len(record.Settings) > 0
+		case record.Type == "SETTINGS" && !record.Ack && record.Direction == azuretls.FrameReceived:
+			recvSettings = true
+		case record.Type == "HEADERS" && record.Direction == azuretls.FrameSent:
+			requestHeaders = record.Headers
+		case record.Type == "DATA" && record.Direction == azuretls.FrameReceived:
+			data = data || record.Length == 2
+		}
+	}
+
+	if !sentSettings || !recvSettings || !data {
+		t.Fatalf("missing frames in log: %s", buf.String())
+	}
+
+	if len(requestHeaders) < 4 {
+		t.Fatalf("expected decoded request headers, got %v", requestHeaders)
+	}
+
+	// pseudo-headers are sent first, in the order of the browser profile
+	order := []string{":method", ":authority", ":scheme", ":path"}
+	for i, name := range order {
+		if requestHeaders[i][0] != name {
+			t.Fatalf("expected %s at position %d, got %v", name, i, requestHeaders)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package azuretls_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Noooste/azuretls-client"
+	"github.com/Noooste/fhttp/httptrace"
+)
+
+type staticResolver []net.IP
+
+func (r staticResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, ip := range r {
+		if network == "ip" || (network == "ip4") == (ip.To4() != nil) {
+			ips = append(ips, ip)
+		}
+	}
+	return ips, nil
+}
+
+func TestHappyEyeballs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	url := "http://race.test:" + port
+
+	// get returns the addresses of the connection attempts of a request
+	get := func(session *azuretls.Session) []string {
+		t.Helper()
+
+		defer session.Close()
+
+		var (
+			mu       sync.Mutex
+			attempts []string
+		)
+
+		ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+			ConnectStart: func(network, addr string) {
+				mu.Lock()
+				attempts = append(attempts, addr)
+				mu.Unlock()
+			},
+		})
+
+		if _, err := session.Get(url, ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		return attempts
+	}
+
+	// the first address is slow to connect to
+	slow := func(session *azuretls.Session) *azuretls.Session {
+		session.HostOverrides = map[string][]string{"race.test": {"127.0.0.2", "127.0.0.1"}}
+		session.ModifyDialer = func(dialer *net.Dialer) error {
+			dialer.Control = func(network, address string, c syscall.RawConn) error {
+				if address == "127.0.0.2:"+port {
+					time.Sleep(500 * time.Millisecond)
+				}
+				return nil
+			}
+			return nil
+		}
+		return session
+	}
+
+	session := slow(azuretls.NewSession())
+	session.HappyEyeballsDelay = 50 * time.Millisecond
+
+	start := time.Now()
+	attempts := get(session)
+
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Fatalf("expected the second address to be tried after 50ms, took %s", elapsed)
+	}
+	if len(attempts) != 2 || attempts[0] != "127.0.0.2:"+port || attempts[1] != "127.0.0.1:"+port {
+		t.Fatalf("expected an attempt to each address, got %v", attempts)
+	}
+
+	session = slow(azuretls.NewSession())
+	session.HappyEyeballsDelay = -1
+
+	start = time.Now()
+	get(session)
+
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Fatalf("expected the addresses to be tried one after another, took %s", elapsed)
+	}
+
+	// the server only listens on IPv4
+	resolver := staticResolver{net.ParseIP("127.0.0.1"), net.ParseIP("::1")}
+
+	session = azuretls.NewSession()
+	session.Resolver = resolver
+
+	if attempts = get(session); len(attempts) == 0 || attempts[0] != "[::1]:"+port {
+		t.Fatalf("expected IPv6 to be tried first, got %v", attempts)
+	}
+
+	session = azuretls.NewSession()
+	session.Resolver = resolver
+	session.AddressFamily = azuretls.IPv4Only
+
+	if attempts = get(session); len(attempts) != 1 || attempts[0] != "127.0.0.1:"+port {
+		t.Fatalf("expected only the IPv4 address to be tried, got %v", attempts)
+	}
+}
@@ -0,0 +1,119 @@
+package azuretls_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Noooste/azuretls-client"
+)
+
+func TestHostOverrides(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.ServerName + " " + r.Host))
+	}))
+	defer server.Close()
+
+	addr := server.Listener.Addr().String()
+	_, port, _ := net.SplitHostPort(addr)
+
+	get := func(session *azuretls.Session, url, expected string) {
+		t.Helper()
+
+		session.InsecureSkipVerify = true
+
+		response, err := session.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(response.Body) != expected {
+			t.Fatalf("expected %q, got %q", expected, response.Body)
+		}
+	}
+
+	session := azuretls.NewSession()
+	defer session.Close()
+
+	// the port of the override replaces the one of the URL
+	session.HostOverrides = map[string][]string{"override.test:443": {"127.0.0.1:" + port}}
+	get(session, "https://override.test", "override.test override.test")
+
+	session = azuretls.NewSession()
+	defer session.Close()
+
+	// the first address does not accept connections
+	session.HostOverrides = map[string][]string{"override.test": {"127.0.0.2", "127.0.0.1"}}
+	get(session, "https://override.test:"+port, "override.test override.test:"+port)
+
+	session = azuretls.NewSession()
+	defer session.Close()
+
+	session.ConnectTo = map[string]string{"connect.test:443": addr}
+	get(session, "https://connect.test", "connect.test connect.test")
+
+	// the target keeps the original host, resolved by HostOverrides
+	session = azuretls.NewSession()
+	defer session.Close()
+
+	session.ConnectTo = map[string]string{"connect.test": ":" + port}
+	session.HostOverrides = map[string][]string{"connect.test": {"127.0.0.1"}}
+	get(session, "https://connect.test", "connect.test connect.test")
+
+	// proxies receive the overridden address as CONNECT target
+	proxy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+
+	targets := make(chan string, 1)
+
+	go func() {
+		for {
+			conn, err := proxy.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				br := bufio.NewReader(conn)
+				req, err := http.ReadRequest(br)
+				if err != nil {
+					return
+				}
+				targets <- req.Host
+
+				upstream, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+
+				_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+
+				go func() { _, _ = io.Copy(upstream, br) }()
+				_, _ = io.Copy(conn, upstream)
+			}()
+		}
+	}()
+
+	session = azuretls.NewSession()
+	defer session.Close()
+
+	if err = session.SetProxy("http://" + proxy.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	session.ConnectTo = map[string]string{"connect.test:443": addr}
+	get(session, "https://connect.test", "connect.test connect.test")
+
+	if target := <-targets; target != addr {
+		t.Fatalf("expected the proxy to connect to %s, got %s", addr, target)
+	}
+}
@@ -0,0 +1,64 @@
+package azuretls_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Noooste/azuretls-client"
+)
+
+func TestKeyLogWriter(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+
+	session := azuretls.NewSession()
+	defer session.Close()
+
+	session.InsecureSkipVerify = true
+	session.KeyLogWriter = &buf
+
+	if _, err := session.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "CLIENT_HANDSHAKE_TRAFFIC_SECRET") && !strings.Contains(buf.String(), "CLIENT_RANDOM") {
+		t.Fatalf("expected TLS secrets to be logged, got %q", buf.String())
+	}
+}
+
+func TestKeyLogEnv(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "keys.log")
+	t.Setenv(azuretls.KeyLogEnv, path)
+
+	session := azuretls.NewSession()
+	defer session.Close()
+
+	session.InsecureSkipVerify = true
+
+	if _, err := session.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(content) == 0 {
+		t.Fatal("expected TLS secrets to be written to " + azuretls.KeyLogEnv)
+	}
+}
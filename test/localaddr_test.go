@@ -0,0 +1,131 @@
+package azuretls_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Noooste/azuretls-client"
+)
+
+// nestedResolver sends two parallel requests with the context of the dial,
+// like the A and AAAA queries of a DoH resolver, before resolving hosts to 127.0.0.1.
+type nestedResolver struct {
+	session *azuretls.Session
+	url     string
+}
+
+func (r *nestedResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := r.session.Get(r.url, ctx)
+			errs <- err
+		}()
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			return nil, err
+		}
+	}
+
+	return []net.IP{net.ParseIP("127.0.0.1")}, nil
+}
+
+func TestLocalAddrPool(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		_, _ = w.Write([]byte(host))
+	}))
+	defer server.Close()
+
+	// get returns the source address of a new connection, as seen by the server
+	get := func(pool *azuretls.LocalAddrPool, url string) string {
+		t.Helper()
+
+		session := azuretls.NewSession()
+		defer session.Close()
+
+		session.LocalAddrPool = pool
+
+		response, err := session.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		local, _, _ := net.SplitHostPort(response.LocalAddr.String())
+		if local != string(response.Body) {
+			t.Fatalf("expected the local address %s, got %s", response.Body, local)
+		}
+
+		return local
+	}
+
+	pool, err := azuretls.NewLocalAddrPool(azuretls.LocalAddrRoundRobin, "127.0.0.2", "127.0.0.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{"127.0.0.2", "127.0.0.3", "127.0.0.2"} {
+		if local := get(pool, server.URL); local != expected {
+			t.Fatalf("expected %s, got %s", expected, local)
+		}
+	}
+
+	// localhost is another host for the sticky strategy
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	other := "http://localhost:" + port
+
+	_, prefix, _ := net.ParseCIDR("127.0.1.0/24")
+
+	pool, err = azuretls.NewLocalAddrPool(azuretls.LocalAddrStickyHost, prefix.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := get(pool, server.URL)
+	if !prefix.Contains(net.ParseIP(first)) {
+		t.Fatalf("expected an address of %s, got %s", prefix, first)
+	}
+
+	for i := 0; i < 3; i++ {
+		if local := get(pool, server.URL); local != first {
+			t.Fatalf("expected the address of the host to be kept, got %s then %s", first, local)
+		}
+	}
+
+	if local := get(pool, other); !prefix.Contains(net.ParseIP(local)) {
+		t.Fatalf("expected an address of %s, got %s", prefix, local)
+	}
+
+	// the connections of requests sharing the context are not reported
+	nested := azuretls.NewSession()
+	defer nested.Close()
+
+	pool, err = azuretls.NewLocalAddrPool(azuretls.LocalAddrRandom, "127.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := azuretls.NewSession()
+	defer session.Close()
+
+	session.LocalAddrPool = pool
+	session.Resolver = &nestedResolver{session: nested, url: server.URL}
+
+	response, err := session.Get("http://nested.test:" + port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if local, _, _ := net.SplitHostPort(response.LocalAddr.String()); local != "127.0.0.2" {
+		t.Fatalf("expected the local address of the request, got %s", local)
+	}
+
+	if _, err = azuretls.NewLocalAddrPool(azuretls.LocalAddrRandom, "not an ip"); err == nil {
+		t.Fatal("expected an invalid address to be rejected")
+	}
+}
@@ -0,0 +1,214 @@
+package azuretls_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Noooste/azuretls-client"
+)
+
+func readLogRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var records []map[string]any
+
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+
+	return records
+}
+
+func TestSetLogger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/final", http.StatusFound)
+			return
+		}
+		w.Header().Set("Set-Cookie", "session=secret")
+		_, _ = w.Write([]byte(`{"token":"abc123"}`))
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+
+	session := azuretls.NewSession()
+	defer session.Close()
+
+	config := azuretls.DefaultLogConfig()
+	config.Headers = true
+	config.Body = true
+	config.RedactBody = []*regexp.Regexp{regexp.MustCompile(`abc\d+`)}
+
+	session.SetLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})), config)
+
+	_, err := session.Get(server.URL+"/redirect", azuretls.OrderedHeaders{
+		{"Authorization", "Bearer token"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := readLogRecords(t, &buf)
+	if len(records) != 4 {
+		t.Fatalf("expected 4 records, got %d: %s", len(records), buf.String())
+	}
+
+	if records[0]["msg"] != azuretls.LogMessageRequest || records[0]["level"] != "DEBUG" {
+		t.Fatalf("unexpected first record: %v", records[0])
+	}
+
+	headers := records[0]["headers"].(map[string]any)
+	if headers["authorization"] != "[REDACTED]" {
+		t.Fatalf("expected authorization to be redacted, got %v", headers["authorization"])
+	}
+
+	first := records[1]
+	if first["msg"] != azuretls.LogMessageResponse || first["status"] != float64(http.StatusFound) || first["redirect_index"] != float64(0) {
+		t.Fatalf("unexpected redirect record: %v", first)
+	}
+
+	last := records[3]
+	if last["status"] != float64(http.StatusOK) || last["redirect_index"] != float64(1) || last["protocol"] != "HTTP/1.1" {
+		t.Fatalf("unexpected final record: %v", last)
+	}
+
+	if last["response_body"] != `{"token":"[REDACTED]"}` {
+		t.Fatalf("expected body to be redacted, got %v", last["response_body"])
+	}
+
+	if last["response_headers"].(map[string]any)["set-cookie"] != "[REDACTED]" {
+		t.Fatalf("expected set-cookie to be redacted, got %v", last["response_headers"])
+	}
+}
+
+func TestSetLoggerError(t *testing.T) {
+	var buf bytes.Buffer
+
+	session := azuretls.NewSession()
+	defer session.Close()
+
+	session.SetLogger(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	if _, err := session.Get("http://127.0.0.1:1"); err == nil {
+		t.Fatal("expected error")
+	}
+
+	records := readLogRecords(t, &buf)
+	if len(records) != 1 {
+		t.Fatalf("expected only the error record at info level, got %d: %s", len(records), buf.String())
+	}
+
+	if records[0]["level"] != "ERROR" || records[0]["error_class"] != azuretls.ErrorClassDial {
+		t.Fatalf("unexpected error record: %v", records[0])
+	}
+}
+
+func TestSetLoggerIgnore(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+
+	session := azuretls.NewSession()
+	defer session.Close()
+
+	session.SetLogger(slog.New(slog.NewJSONHandler(&buf, nil)))
+	session.Log("/ignored")
+
+	if _, err := session.Get(server.URL + "/ignored"); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.Len() != 0 {
+		t.Fatalf("expected ignored uri not to be logged, got %s", buf.String())
+	}
+
+	if _, err := session.Get(server.URL + "/logged"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(readLogRecords(t, &buf)) != 1 {
+		t.Fatalf("expected one record, got %s", buf.String())
+	}
+}
+
+func TestColorHandler(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+
+	session := azuretls.NewSession()
+	defer session.Close()
+
+	session.SetLogger(slog.New(azuretls.NewColorHandler(&buf)), azuretls.LogConfig{})
+
+	if _, err := session.Get(server.URL + "/color"); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected request and response lines, got %q", buf.String())
+	}
+
+	if !strings.Contains(lines[0], "AZURETLS") || !strings.Contains(lines[0], "GET") || !strings.Contains(lines[0], `"/color"`) {
+		t.Fatalf("unexpected request line %q", lines[0])
+	}
+
+	if !strings.Contains(lines[1], "200") || !strings.Contains(lines[1], "HTTP/1.1") {
+		t.Fatalf("unexpected response line %q", lines[1])
+	}
+}
+
+func TestSetLoggerRedactURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+
+	session := azuretls.NewSession()
+	defer session.Close()
+
+	session.SetLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	u := strings.Replace(server.URL, "http://", "http://user:pass@", 1) + "/path?Token=abc123&page=2"
+	if _, err := session.Get(u); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(buf.String(), "pass@") || strings.Contains(buf.String(), "abc123") {
+		t.Fatalf("expected credentials to be redacted: %s", buf.String())
+	}
+
+	records := readLogRecords(t, &buf)
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d: %s", len(records), buf.String())
+	}
+
+	expected := strings.Replace(server.URL, "http://", "http://user:xxxxx@", 1) + "/path?Token=[REDACTED]&page=2"
+	for _, record := range records {
+		if record["url"] != expected {
+			t.Fatalf("expected url %s, got %v", expected, record["url"])
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package azuretls_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Noooste/azuretls-client"
+	"github.com/Noooste/azuretls-client/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func gatherMetric(t *testing.T, reg *prometheus.Registry, name string) []*dto.Metric {
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range families {
+		if f.GetName() == name {
+			return f.GetMetric()
+		}
+	}
+
+	return nil
+}
+
+func labelValue(m *dto.Metric, name string) string {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+func TestMetricsCollector(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/final", http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	collector := metrics.NewCollector()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collector)
+
+	session := azuretls.NewSession()
+	defer session.Close()
+
+	collector.Instrument("test", session)
+
+	resp, err := session.Get(server.URL + "/redirect")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	requests := gatherMetric(t, reg, "azuretls_requests_total")
+	var codes = make(map[string]float64)
+	for _, m := range requests {
+		if labelValue(m, "session") != "test" {
+			t.Fatalf("unexpected session label %q", labelValue(m, "session"))
+		}
+		codes[labelValue(m, "code")] += m.GetCounter().GetValue()
+	}
+
+	if codes["302"] != 1 || codes["200"] != 1 {
+		t.Fatalf("unexpected request counts: %v", codes)
+	}
+
+	redirects := gatherMetric(t, reg, "azuretls_redirects_total")
+	if len(redirects) != 1 || labelValue(redirects[0], "host") != strings.TrimPrefix(server.URL, "http://") || redirects[0].GetCounter().GetValue() != 1 {
+		t.Fatalf("expected one redirect, got %v", redirects)
+	}
+
+	// following a redirect does not send the request again
+	if retries := gatherMetric(t, reg, "azuretls_retries_total"); len(retries) != 0 {
+		t.Fatalf("expected no retry, got %v", retries)
+	}
+
+	open := gatherMetric(t, reg, "azuretls_open_connections")
+	if len(open) != 1 || labelValue(open[0], "proto") != azuretls.ProtoHTTP1 || open[0].GetGauge().GetValue() != 1 {
+		t.Fatalf("expected one open h1 connection, got %v", open)
+	}
+
+	received := gatherMetric(t, reg, "azuretls_received_bytes_total")
+	if len(received) == 0 || received[0].GetCounter().GetValue() == 0 {
+		t.Fatal("expected received bytes to be counted")
+	}
+
+	if len(gatherMetric(t, reg, "azuretls_request_duration_seconds")) == 0 {
+		t.Fatal("expected latency to be observed")
+	}
+}
+
+func TestMetricsCollectorProxyFailure(t *testing.T) {
+	collector := metrics.NewCollector()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collector)
+
+	session := azuretls.NewSession()
+	defer session.Close()
+
+	collector.Instrument("proxy", session)
+
+	if err := session.SetProxy("http://127.0.0.1:1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Get("https://example.com"); err == nil {
+		t.Fatal("expected proxy dial to fail")
+	}
+
+	failures := gatherMetric(t, reg, "azuretls_proxy_failures_total")
+	if len(failures) != 1 || labelValue(failures[0], "proxy") != "http://127.0.0.1:1" {
+		t.Fatalf("expected one proxy failure, got %v", failures)
+	}
+
+	requests := gatherMetric(t, reg, "azuretls_requests_total")
+	if len(requests) != 1 || labelValue(requests[0], "code") != "error" {
+		t.Fatalf("expected one errored request, got %v", requests)
+	}
+}
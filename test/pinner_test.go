@@ -1,10 +1,17 @@
 package azuretls_test
 
 import (
-	"github.com/Noooste/azuretls-client"
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"testing"
+
+	"github.com/Noooste/azuretls-client"
 )
 
 func TestPins(t *testing.T) {
@@ -181,3 +188,65 @@ func TestCertificateChainDifference(t *testing.T) {
 		t.Log("All pins are identical")
 	}
 }
+
+func TestPinsThroughSessionDialer(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	var dials atomic.Int32
+
+	newSession := func() *azuretls.Session {
+		session := azuretls.NewSession()
+		session.PinManager = azuretls.NewPinManager()
+		session.RootCAs = pool
+
+		// example.com is only reachable through the dialer of the session
+		session.Dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dials.Add(1)
+			return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+		}
+		return session
+	}
+
+	session := newSession()
+	defer session.Close()
+
+	if _, err := session.Get("https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the pins come from the handshake of the request, without another connection
+	if n := dials.Load(); n != 1 {
+		t.Fatalf("expected a single connection, got %d", n)
+	}
+
+	pins := session.PinManager.GetHost("example.com:443")
+	if pins == nil || !pins.Verify(server.Certificate()) {
+		t.Fatal("expected the certificate of the server to be pinned")
+	}
+
+	// pins generated explicitly go through the same dialer
+	pm := azuretls.NewPinManager()
+	if err := pm.AddHost("example.com:443", newSession()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := dials.Load(); n != 2 {
+		t.Fatalf("expected the pin generation to use the session dialer, got %d dials", n)
+	}
+
+	// existing pins are still verified
+	session = newSession()
+	defer session.Close()
+
+	if err := session.AddPins(&url.URL{Scheme: "https", Host: "example.com"}, []string{"not a good pin here"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Get("https://example.com"); err == nil || !strings.Contains(err.Error(), "pin verification failed") {
+		t.Fatalf("expected pin verification to fail, got %v", err)
+	}
+}
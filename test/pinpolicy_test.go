@@ -0,0 +1,121 @@
+package azuretls_test
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Noooste/azuretls-client"
+)
+
+func TestPinPolicy(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	pin := azuretls.Fingerprint(server.Certificate())
+
+	// the certificate of the test server is valid for example.com and its subdomains
+	get := func(pm *azuretls.PinManager, u string) error {
+		t.Helper()
+
+		session := azuretls.NewSession()
+		defer session.Close()
+
+		session.PinManager = pm
+		session.RootCAs = pool
+		session.Dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+		}
+
+		_, err := session.Get(u)
+		return err
+	}
+
+	pm := azuretls.NewPinManager()
+	if err := pm.SetPolicy(azuretls.PinPolicy{Host: "example.com", Pins: []string{"bad"}, BackupPins: []string{pin}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := get(pm, "https://example.com"); err != nil {
+		t.Fatalf("expected the backup pin to be accepted, got %v", err)
+	}
+
+	// subdomains only use the policy of their parent with IncludeSubdomains
+	pm = azuretls.NewPinManager()
+	_ = pm.SetPolicy(azuretls.PinPolicy{Host: "example.com:443", Pins: []string{"bad"}})
+
+	if err := get(pm, "https://www.example.com"); err != nil {
+		t.Fatalf("expected www.example.com to be pinned on first use, got %v", err)
+	}
+
+	_ = pm.SetPolicy(azuretls.PinPolicy{Host: "example.com:443", Pins: []string{"bad"}, IncludeSubdomains: true})
+
+	if err := get(pm, "https://api.example.com"); err == nil || !strings.Contains(err.Error(), "pin verification failed") {
+		t.Fatalf("expected the policy of example.com to apply to api.example.com, got %v", err)
+	}
+
+	// report-only mode calls OnPinFailure without failing the request
+	var reported string
+
+	pm.ReportOnly = true
+	pm.OnPinFailure = func(host string, chain []*x509.Certificate) {
+		reported = host
+		if len(chain) == 0 || !chain[0].Equal(server.Certificate()) {
+			t.Errorf("expected the chain of the server, got %v", chain)
+		}
+	}
+
+	if err := get(pm, "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if reported != "example.com:443" {
+		t.Fatalf("expected the failure of example.com:443 to be reported, got %q", reported)
+	}
+
+	// expired pins are replaced by the certificates of the next connection
+	var repinned azuretls.PinPolicy
+
+	pm = azuretls.NewPinManager()
+	pm.MaxAge = time.Hour
+	pm.OnPin = func(policy azuretls.PinPolicy) {
+		repinned = policy
+	}
+
+	_ = pm.SetPolicy(azuretls.PinPolicy{Host: "example.com", Pins: []string{"bad"}, MaxAge: 1, PinnedAt: time.Now().Add(-time.Minute)})
+
+	if err := get(pm, "https://example.com"); err != nil {
+		t.Fatalf("expected the expired policy to be renewed, got %v", err)
+	}
+
+	if repinned.Host != "example.com:443" || !reflect.DeepEqual(repinned.Pins, []string{pin}) || repinned.MaxAge != 3600 {
+		t.Fatalf("expected the new pins to be reported, got %+v", repinned)
+	}
+
+	// policies are saved and loaded
+	file := filepath.Join(t.TempDir(), "pins.json")
+	if err := pm.SaveFile(file); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := azuretls.NewPinManager()
+	if err := loaded.LoadFile(file); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, restored := pm.Policies(), loaded.Policies()
+	if len(saved) != 1 || len(restored) != 1 || !reflect.DeepEqual(saved[0].Pins, restored[0].Pins) ||
+		saved[0].MaxAge != restored[0].MaxAge || !saved[0].PinnedAt.Equal(restored[0].PinnedAt) {
+		t.Fatalf("expected %+v, got %+v", saved, restored)
+	}
+}
@@ -0,0 +1,192 @@
+package azuretls_test
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Noooste/azuretls-client"
+	"github.com/Noooste/fhttp/httptrace"
+	utls "github.com/Noooste/utls"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsServer answers the A queries of resolver.test with 127.0.0.1.
+type dnsServer struct {
+	queries atomic.Int32
+}
+
+func (d *dnsServer) answer(t *testing.T, query []byte) []byte {
+	d.queries.Add(1)
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil {
+		t.Error(err)
+		return nil
+	}
+
+	msg.Header.Response = true
+	question := msg.Questions[0]
+
+	switch {
+	case question.Name.String() != "resolver.test.":
+		msg.Header.RCode = dnsmessage.RCodeNameError
+	case question.Type == dnsmessage.TypeA:
+		msg.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+			Body:   &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+		}}
+	}
+
+	packed, err := msg.Pack()
+	if err != nil {
+		t.Error(err)
+	}
+	return packed
+}
+
+func TestResolver(t *testing.T) {
+	dns := &dnsServer{}
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Host))
+	}))
+	defer target.Close()
+
+	_, port, _ := net.SplitHostPort(target.Listener.Addr().String())
+	url := "http://resolver.test:" + port
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = udp.WriteTo(dns.answer(t, buf[:n]), addr)
+		}
+	}()
+
+	doh := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(dns.answer(t, query))
+	}))
+	defer doh.Close()
+
+	dot, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: doh.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dot.Close()
+
+	go func() {
+		for {
+			conn, err := dot.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				var length [2]byte
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+
+				response := dns.answer(t, query)
+				binary.BigEndian.PutUint16(length[:], uint16(len(response)))
+				_, _ = conn.Write(append(length[:], response...))
+			}()
+		}
+	}()
+
+	dohResolver := azuretls.NewDoHResolver(doh.URL + "/dns-query")
+	dohResolver.Session.InsecureSkipVerify = true
+	defer dohResolver.Session.Close()
+
+	resolvers := map[string]azuretls.Resolver{
+		"udp": &azuretls.UDPResolver{Addr: udp.LocalAddr().String()},
+		"dot": &azuretls.DoTResolver{Addr: dot.Addr().String(), Config: &utls.Config{InsecureSkipVerify: true}},
+		"doh": dohResolver,
+	}
+
+	for name, resolver := range resolvers {
+		session := azuretls.NewSession()
+		session.Resolver = resolver
+
+		response, err := session.Get(url)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if !strings.HasPrefix(string(response.Body), "resolver.test:") {
+			t.Fatalf("%s: expected the Host of the request, got %s", name, response.Body)
+		}
+
+		if _, err = session.Get("http://unknown.test:" + port); err == nil {
+			t.Fatalf("%s: expected unknown.test not to resolve", name)
+		}
+
+		session.Close()
+	}
+
+	// the trace of the request is not called for the connection of the DoH query
+	var conns atomic.Int32
+
+	session := azuretls.NewSession()
+	session.Resolver = dohResolver
+
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) { conns.Add(1) },
+	})
+
+	if _, err = session.Get(url, ctx); err != nil {
+		t.Fatal(err)
+	}
+	session.Close()
+
+	if n := conns.Load(); n != 1 {
+		t.Fatalf("expected the trace to see the connection of the request only, got %d connections", n)
+	}
+
+	// the cache answers the following lookups for the TTL of the records
+	cache := azuretls.NewCachingResolver(resolvers["udp"])
+
+	dns.queries.Store(0)
+
+	for i := 0; i < 3; i++ {
+		session := azuretls.NewSession()
+		session.Resolver = cache
+
+		if _, err = session.Get(url); err != nil {
+			t.Fatal(err)
+		}
+
+		session.Close()
+	}
+
+	// an A and an AAAA query
+	if queries := dns.queries.Load(); queries != 2 {
+		t.Fatalf("expected the addresses to be cached, got %d queries", queries)
+	}
+}
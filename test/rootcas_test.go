@@ -0,0 +1,70 @@
+package azuretls_test
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Noooste/azuretls-client"
+)
+
+func TestRootCAs(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	get := func(configure func(session *azuretls.Session)) error {
+		t.Helper()
+
+		session := azuretls.NewSession()
+		defer session.Close()
+
+		// pins are generated by every session
+		session.PinManager = azuretls.NewPinManager()
+		configure(session)
+
+		_, err := session.Get(server.URL)
+		return err
+	}
+
+	if err := get(func(session *azuretls.Session) {}); err == nil {
+		t.Fatal("expected the certificate of the test server not to be trusted by the system roots")
+	}
+
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	pool, err := azuretls.LoadCertPool(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = get(func(session *azuretls.Session) { session.RootCAs = pool }); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = get(func(session *azuretls.Session) { session.AdditionalRootCAs = pool }); err != nil {
+		t.Fatal(err)
+	}
+
+	// RootCAs replaces the roots, AdditionalRootCAs extends them
+	if err = get(func(session *azuretls.Session) { session.RootCAs = x509.NewCertPool() }); err == nil {
+		t.Fatal("expected the certificate not to be trusted by an empty RootCAs")
+	}
+
+	if err = get(func(session *azuretls.Session) {
+		session.RootCAs = x509.NewCertPool()
+		session.AdditionalRootCAs = pool
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = azuretls.LoadCertPool(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,111 @@
+package azuretls_test
+
+import (
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Noooste/azuretls-client"
+)
+
+func TestServerName(t *testing.T) {
+	// the certificate of the server is valid for example.com and 127.0.0.1
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.ServerName + " " + r.Host))
+	}))
+	defer server.Close()
+
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	do := func(session *azuretls.Session, request *azuretls.Request) (string, error) {
+		t.Helper()
+
+		defer session.Close()
+
+		session.PinManager = azuretls.NewPinManager()
+		session.RootCAs = pool
+		session.HostOverrides = map[string][]string{
+			"example.com": {"127.0.0.1"},
+			"front.test":  {"127.0.0.1"},
+		}
+
+		response, err := session.Do(request)
+		if err != nil {
+			return "", err
+		}
+		return string(response.Body), nil
+	}
+
+	expect := func(request *azuretls.Request, expected string) {
+		t.Helper()
+
+		body, err := do(azuretls.NewSession(), request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != expected {
+			t.Fatalf("expected %q, got %q", expected, body)
+		}
+	}
+
+	expect(&azuretls.Request{
+		Method:     http.MethodGet,
+		Url:        "https://127.0.0.1:" + port,
+		ServerName: "example.com",
+	}, "example.com 127.0.0.1:"+port)
+
+	// without SNI, the certificate is verified against the host
+	expect(&azuretls.Request{
+		Method:         http.MethodGet,
+		Url:            "https://127.0.0.1:" + port,
+		OmitServerName: true,
+	}, " 127.0.0.1:"+port)
+
+	expect(&azuretls.Request{
+		Method:     http.MethodGet,
+		Url:        "https://example.com:" + port,
+		ServerName: "127.0.0.1",
+	}, "127.0.0.1 example.com:"+port)
+
+	// the certificate is not valid for the front domain
+	_, err := do(azuretls.NewSession(), &azuretls.Request{
+		Method:     http.MethodGet,
+		Url:        "https://example.com:" + port,
+		ServerName: "front.test",
+	})
+	if err == nil {
+		t.Fatal("expected the certificate to be verified against front.test")
+	}
+
+	expect(&azuretls.Request{
+		Method:     http.MethodGet,
+		Url:        "https://example.com:" + port,
+		ServerName: "front.test",
+		VerifyName: "example.com",
+	}, "front.test example.com:"+port)
+
+	session := azuretls.NewSession()
+	session.VerifyName = func(host, serverName string) string {
+		if serverName == "front.test" {
+			return host
+		}
+		return ""
+	}
+
+	body, err := do(session, &azuretls.Request{
+		Method:     http.MethodGet,
+		Url:        "https://example.com:" + port,
+		ServerName: "front.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "front.test example.com:"+port {
+		t.Fatalf("expected the certificate to be verified against the host, got %q", body)
+	}
+}
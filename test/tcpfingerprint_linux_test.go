@@ -0,0 +1,118 @@
+package azuretls_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+
+	"github.com/Noooste/azuretls-client"
+	"github.com/Noooste/fhttp/httptrace"
+)
+
+func TestTCPFingerprint(t *testing.T) {
+	mss := make(chan int, 1)
+
+	// the MSS of the server is bounded by the one announced in the SYN of the client
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state != http.StateNew {
+			return
+		}
+
+		raw, err := conn.(*net.TCPConn).SyscallConn()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		_ = raw.Control(func(fd uintptr) {
+			value, err := syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_MAXSEG)
+			if err != nil {
+				t.Error(err)
+			}
+			mss <- value
+		})
+	}
+	server.Start()
+	defer server.Close()
+
+	get := func(session *azuretls.Session) int {
+		t.Helper()
+
+		defer session.Close()
+
+		if _, err := session.Get(server.URL); err != nil {
+			t.Fatal(err)
+		}
+
+		return <-mss
+	}
+
+	if value := get(azuretls.NewSession()); value <= 1460 {
+		t.Fatalf("expected the MSS of the loopback interface, got %d", value)
+	}
+
+	session := azuretls.NewSession()
+	session.TCPFingerprint = &azuretls.TCPFingerprint{TTL: 100, MSS: 1000, WindowClamp: 65535}
+
+	if value := get(session); value > 1000 {
+		t.Fatalf("expected an MSS of 1000, got %d", value)
+	}
+
+	// the user agent of the session is the one of Chrome on Windows
+	session = azuretls.NewSession()
+	session.ShapeTCPFingerprint = true
+
+	if value := get(session); value > 1460 {
+		t.Fatalf("expected the MSS of Windows, got %d", value)
+	}
+
+	// noDelay returns the TCP_NODELAY option of the connection of a request
+	noDelay := func(fingerprint *azuretls.TCPFingerprint) int {
+		t.Helper()
+
+		session := azuretls.NewSession()
+		defer session.Close()
+
+		session.TCPFingerprint = fingerprint
+
+		value := -1
+		ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				conn, ok := info.Conn.(syscall.Conn)
+				if !ok {
+					return
+				}
+
+				raw, err := conn.SyscallConn()
+				if err != nil {
+					return
+				}
+
+				_ = raw.Control(func(fd uintptr) {
+					value, _ = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+				})
+			},
+		})
+
+		if _, err := session.Get(server.URL, ctx); err != nil {
+			t.Fatal(err)
+		}
+		<-mss
+
+		return value
+	}
+
+	// a fingerprint without NoDelay keeps Nagle's algorithm disabled
+	if value := noDelay(&azuretls.TCPFingerprint{TTL: 128}); value != 1 {
+		t.Fatalf("expected TCP_NODELAY to be kept, got %d", value)
+	}
+
+	off := false
+	if value := noDelay(&azuretls.TCPFingerprint{TTL: 128, NoDelay: &off}); value != 0 {
+		t.Fatalf("expected TCP_NODELAY to be cleared, got %d", value)
+	}
+}
@@ -0,0 +1,246 @@
+package azuretls_test
+
+import (
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/Noooste/azuretls-client"
+)
+
+// pskJa3 is a Chrome fingerprint with the pre_shared_key extension (41)
+const pskJa3 = "771,4865-4866-4867-49195-49199-49196-49200-52393-52392-49171-49172-156-157-47-53,65281-27-0-51-65037-23-5-35-11-13-10-16-18-43-45-17613-41,29-23-24,0"
+
+func TestSessionResumption(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strconv.FormatBool(r.TLS.DidResume)))
+	}))
+	defer server.Close()
+
+	resumed := func(session *azuretls.Session) bool {
+		t.Helper()
+
+		session.InsecureSkipVerify = true
+
+		response, err := session.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(response.Body) == "true"
+	}
+
+	var stored int
+
+	first := azuretls.NewSession()
+	cache := azuretls.NewTicketCache(0)
+	first.ClientSessionCache = cache
+	cache.OnStore = func(ticket azuretls.SessionTicket) {
+		stored++
+	}
+
+	if resumed(first) {
+		t.Fatal("expected a full handshake on the first connection")
+	}
+	first.Close()
+
+	if stored == 0 {
+		t.Fatal("expected OnStore to be called with the session ticket")
+	}
+
+	// a new connection sharing the cache resumes the session with a PSK
+	second := azuretls.NewSession()
+	defer second.Close()
+
+	second.ClientSessionCache = cache
+	if !resumed(second) {
+		t.Fatal("expected the second connection to resume the TLS session")
+	}
+
+	// the tickets can be persisted and loaded in another session
+	third := azuretls.NewSession()
+	defer third.Close()
+
+	third.ClientSessionCache = azuretls.NewTicketCache(0)
+	if err := third.ClientSessionCache.(*azuretls.TicketCache).Import(cache.Export()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := third.ApplyJa3(pskJa3, azuretls.Chrome); err != nil {
+		t.Fatal(err)
+	}
+
+	if !resumed(third) {
+		t.Fatal("expected an imported ticket to be resumed")
+	}
+
+	// without a cache, the default, every connection performs a full handshake
+	fourth := azuretls.NewSession()
+	defer fourth.Close()
+
+	if resumed(fourth) || resumed(fourth) {
+		t.Fatal("expected a full handshake without a ClientSessionCache")
+	}
+
+	// the least recently used sessions are evicted beyond the capacity
+	tickets := cache.Export()
+	other := tickets[0]
+	other.Key = "other"
+
+	var evicted []string
+
+	small := azuretls.NewTicketCache(1)
+	small.OnEvict = func(key string) {
+		evicted = append(evicted, key)
+	}
+
+	if err := small.Import(append(tickets, other)); err != nil {
+		t.Fatal(err)
+	}
+
+	if exported := small.Export(); len(exported) != 1 || exported[0].Key != "other" {
+		t.Fatalf("expected only the last ticket to be kept, got %+v", exported)
+	}
+
+	small.Put(tickets[0].Key, nil)
+	if len(evicted) != 1 || evicted[0] != tickets[0].Key {
+		t.Fatalf("expected OnEvict to be called with %s, got %v", tickets[0].Key, evicted)
+	}
+}
+
+// connectProxy tunnels the CONNECT requests it receives.
+func connectProxy(w http.ResponseWriter, r *http.Request) {
+	upstream, err := net.Dial("tcp", r.Host)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+
+	go func() {
+		_, _ = io.Copy(upstream, conn)
+	}()
+	_, _ = io.Copy(conn, upstream)
+}
+
+func TestSessionResumptionVerified(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strconv.FormatBool(r.TLS.DidResume)))
+	}))
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	cache := azuretls.NewTicketCache(0)
+
+	resumed := func(configure func(session *azuretls.Session)) bool {
+		t.Helper()
+
+		session := azuretls.NewSession()
+		defer session.Close()
+
+		session.PinManager = azuretls.NewPinManager()
+		session.RootCAs = pool
+		session.ClientSessionCache = cache
+		configure(session)
+
+		response, err := session.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(response.Body) == "true"
+	}
+
+	if resumed(func(session *azuretls.Session) {}) {
+		t.Fatal("expected a full handshake on the first connection")
+	}
+
+	if !resumed(func(session *azuretls.Session) {}) {
+		t.Fatal("expected the second connection to resume the TLS session")
+	}
+
+	// pins are generated with a handshake of the browser profile
+	resumed(func(session *azuretls.Session) {
+		if err := session.PinManager.AddHost(server.Listener.Addr().String(), session); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// the HTTPS proxy of a chain is reached with the browser profile
+	httpProxy := httptest.NewServer(http.HandlerFunc(connectProxy))
+	defer httpProxy.Close()
+
+	httpsProxy := httptest.NewTLSServer(http.HandlerFunc(connectProxy))
+	defer httpsProxy.Close()
+
+	if !resumed(func(session *azuretls.Session) {
+		if err := session.SetProxyChain([]string{httpProxy.URL, httpsProxy.URL}); err != nil {
+			t.Fatal(err)
+		}
+	}) {
+		t.Fatal("expected the TLS session to be resumed through the proxy chain")
+	}
+}
+
+func TestSessionResumptionPins(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strconv.FormatBool(r.TLS.DidResume)))
+	}))
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	cache := azuretls.NewTicketCache(0)
+	pins := azuretls.NewPinManager()
+
+	get := func() (bool, error) {
+		t.Helper()
+
+		session := azuretls.NewSession()
+		defer session.Close()
+
+		session.PinManager = pins
+		session.RootCAs = pool
+		session.ClientSessionCache = cache
+
+		response, err := session.Get(server.URL)
+		if err != nil {
+			return false, err
+		}
+
+		return string(response.Body) == "true", nil
+	}
+
+	// the first connection pins the certificate of the server
+	if _, err := get(); err != nil {
+		t.Fatal(err)
+	}
+
+	if resumed, err := get(); err != nil || !resumed {
+		t.Fatalf("expected the pinned session to be resumed, got %v, %v", resumed, err)
+	}
+
+	// pins are verified on resumed handshakes too
+	addr := server.Listener.Addr().String()
+	pins.Clear(addr)
+	pins.AddPins(addr, []string{"not a good pin here"})
+
+	if _, err := get(); err == nil {
+		t.Fatal("expected the resumed handshake to fail with the new pins")
+	}
+}
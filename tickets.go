@@ -0,0 +1,197 @@
+package azuretls
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+
+	tls "github.com/Noooste/utls"
+)
+
+// defaultTicketCacheCapacity is the capacity of a TicketCache created with
+// a capacity below 1, like tls.NewLRUClientSessionCache.
+const defaultTicketCacheCapacity = 64
+
+// TicketCache is a tls.ClientSessionCache keeping the latest TLS session of
+// the most recently used servers. Unlike tls.NewLRUClientSessionCache, its
+// content can be exported and imported, which Snapshot and RestoreSession rely on.
+type TicketCache struct {
+	mu       sync.Mutex
+	capacity int
+	sessions map[string]*list.Element
+	lru      *list.List // front is the most recently used
+
+	// OnStore is called when a server sends a new session ticket, e.g. to
+	// persist it. It must not block the handshake.
+	OnStore func(ticket SessionTicket)
+	// OnEvict is called when the session of key is removed, e.g. because the
+	// server refused to resume it or the cache is full.
+	OnEvict func(key string)
+}
+
+type ticketCacheEntry struct {
+	key string
+	cs  *tls.ClientSessionState
+}
+
+// NewTicketCache returns an empty TicketCache holding the sessions of at most
+// capacity servers. If capacity is below 1, a default capacity is used.
+func NewTicketCache(capacity int) *TicketCache {
+	if capacity < 1 {
+		capacity = defaultTicketCacheCapacity
+	}
+
+	return &TicketCache{
+		capacity: capacity,
+		sessions: make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Get implements tls.ClientSessionCache.
+func (c *TicketCache) Get(sessionKey string) (*tls.ClientSessionState, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.sessions[sessionKey]
+	if !ok {
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*ticketCacheEntry).cs, true
+}
+
+// Put implements tls.ClientSessionCache.
+func (c *TicketCache) Put(sessionKey string, cs *tls.ClientSessionState) {
+	var evicted []string
+
+	c.mu.Lock()
+	if cs == nil {
+		if elem, ok := c.sessions[sessionKey]; ok {
+			c.lru.Remove(elem)
+			delete(c.sessions, sessionKey)
+		}
+		evicted = append(evicted, sessionKey)
+	} else {
+		evicted = c.set(sessionKey, cs)
+	}
+	c.mu.Unlock()
+
+	if c.OnEvict != nil {
+		for _, key := range evicted {
+			c.OnEvict(key)
+		}
+	}
+
+	if cs != nil && c.OnStore != nil {
+		if ticket, ok := exportTicket(sessionKey, cs); ok {
+			c.OnStore(ticket)
+		}
+	}
+}
+
+// set stores the session of key and returns the keys of the sessions removed
+// to stay within the capacity. c.mu must be held.
+func (c *TicketCache) set(key string, cs *tls.ClientSessionState) (evicted []string) {
+	if elem, ok := c.sessions[key]; ok {
+		elem.Value.(*ticketCacheEntry).cs = cs
+		c.lru.MoveToFront(elem)
+		return nil
+	}
+
+	c.sessions[key] = c.lru.PushFront(&ticketCacheEntry{key: key, cs: cs})
+
+	for c.lru.Len() > c.capacity {
+		entry := c.lru.Remove(c.lru.Back()).(*ticketCacheEntry)
+		delete(c.sessions, entry.key)
+		evicted = append(evicted, entry.key)
+	}
+
+	return evicted
+}
+
+// quicSessionCacheKeyPrefix separates the sessions of QUIC connections, which
+// cannot be resumed over TCP, from the sessions of TCP connections.
+const quicSessionCacheKeyPrefix = "quic:"
+
+type prefixedSessionCache struct {
+	tls.ClientSessionCache
+	prefix string
+}
+
+func (c *prefixedSessionCache) Get(sessionKey string) (*tls.ClientSessionState, bool) {
+	return c.ClientSessionCache.Get(c.prefix + sessionKey)
+}
+
+func (c *prefixedSessionCache) Put(sessionKey string, cs *tls.ClientSessionState) {
+	c.ClientSessionCache.Put(c.prefix+sessionKey, cs)
+}
+
+// quicSessionCache returns the cache of QUIC connections sharing cache with TCP connections.
+func quicSessionCache(cache tls.ClientSessionCache) tls.ClientSessionCache {
+	if cache == nil {
+		return nil
+	}
+	return &prefixedSessionCache{ClientSessionCache: cache, prefix: quicSessionCacheKeyPrefix}
+}
+
+// Export implements TicketStore.
+func (c *TicketCache) Export() []SessionTicket {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tickets := make([]SessionTicket, 0, len(c.sessions))
+	for key, elem := range c.sessions {
+		if ticket, ok := exportTicket(key, elem.Value.(*ticketCacheEntry).cs); ok {
+			tickets = append(tickets, ticket)
+		}
+	}
+
+	sort.Slice(tickets, func(i, j int) bool {
+		return tickets[i].Key < tickets[j].Key
+	})
+
+	return tickets
+}
+
+// Import implements TicketStore. If there are more tickets than the capacity
+// of the cache, the last ones are kept.
+func (c *TicketCache) Import(tickets []SessionTicket) error {
+	for _, t := range tickets {
+		state, err := tls.ParseSessionState(t.State)
+		if err != nil {
+			return err
+		}
+
+		cs, err := tls.NewResumptionState(t.Ticket, state)
+		if err != nil {
+			return err
+		}
+
+		// not reported to OnStore and OnEvict, the tickets are already persisted
+		c.mu.Lock()
+		c.set(t.Key, cs)
+		c.mu.Unlock()
+	}
+
+	return nil
+}
+
+func exportTicket(key string, cs *tls.ClientSessionState) (SessionTicket, bool) {
+	ticket, state, err := cs.ResumptionState()
+	if err != nil || state == nil {
+		return SessionTicket{}, false
+	}
+
+	raw, err := state.Bytes()
+	if err != nil {
+		return SessionTicket{}, false
+	}
+
+	return SessionTicket{
+		Key:    key,
+		Ticket: ticket,
+		State:  raw,
+	}, true
+}
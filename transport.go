@@ -29,7 +29,7 @@ func (s *Session) initHTTP1() {
 		TLSHandshakeTimeout:   s.TimeOut,
 		ResponseHeaderTimeout: s.TimeOut,
 		DialTLSContext:        s.dialTLS,
-		DialContext:           s.dial,
+		DialContext:           s.dialPlain,
 		MaxIdleConns:          1e3,
 		IdleConnTimeout:       90 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
@@ -48,6 +48,8 @@ func (s *Session) getDefaultHTTP2Transport() (*http2.Transport, error) {
 	tr.StrictMaxConcurrentStreams = true
 	tr.PushHandler = &http2.DefaultPushHandler{}
 
+	s.installFrameLog(s.Transport, tr)
+
 	return tr, nil
 }
 
@@ -32,6 +32,7 @@ const (
 	forceHTTP1Key         = "force-http1"
 	userAgentKey          = "user-agent"
 	insecureSkipVerifyKey = "insecure-skip-verify"
+	serverNameKey         = "server-name"
 )
 
 var (
@@ -65,16 +65,8 @@ func (s *Session) NewWebsocketWithContext(ctx context.Context, url string, readB
 		return nil, err
 	}
 
-	if !req.NoCookie {
-		cookies := s.CookieJar.Cookies(req.parsedUrl)
-		if cookies != nil && len(cookies) > 0 {
-			if c := req.HttpRequest.Header.Get("Cookie"); c != "" {
-				req.HttpRequest.Header.Set("Cookie", c+"; "+CookiesToString(cookies))
-			} else {
-				req.HttpRequest.Header.Set("Cookie", CookiesToString(cookies))
-			}
-		}
-	}
+	// a websocket is never a top-level navigation
+	req.Subresource = true
 
 	req.ForceHTTP1 = true
 
@@ -82,7 +74,7 @@ func (s *Session) NewWebsocketWithContext(ctx context.Context, url string, readB
 		HandshakeTimeout: s.TimeOut,
 		ReadBufferSize:   readBufferSize,
 		WriteBufferSize:  writeBufferSize,
-		Jar:              s.CookieJar,
+		Jar:              &websocketJar{session: s, request: req},
 		NetDialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
 			ctx = context.WithValue(ctx, forceHTTP1Key, true)
 			return s.dialTLS(ctx, network, addr)
@@ -104,3 +96,21 @@ func (s *Session) NewWebsocketWithContext(ctx context.Context, url string, readB
 		Response: resp,
 	}, nil
 }
+
+// websocketJar gives the site context of the request to the session jar
+// for the cookies of the handshake, which is sent to an http(s) URL by the dialer.
+type websocketJar struct {
+	session *Session
+	request *Request
+}
+
+func (j *websocketJar) Cookies(u *url2.URL) []*http.Cookie {
+	if j.request.NoCookie {
+		return nil
+	}
+	return j.session.requestCookies(j.request, u)
+}
+
+func (j *websocketJar) SetCookies(u *url2.URL, cookies []*http.Cookie) {
+	j.session.setResponseCookies(&Response{Request: j.request}, u, cookies)
+}